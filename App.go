@@ -15,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"text/template"
@@ -46,17 +47,23 @@ type App struct {
 	Config        *AppConfig
 	Router        *Router
 	Filters       []Filter
+	Middlewares   []Middleware
 	StaticFilters []Filter
 	ByteCaches    *ByteCacheCollection
 	GenericCaches *GenericCacheCollection
 	Random        *rand.Rand
 	HTTPErrorFunc func(w http.ResponseWriter, r *http.Request, err int)
+	ErrorFunc     func(in *In, err error)
 	GetLangFunc   func(w http.ResponseWriter, r *http.Request) string
 	ServeMux      *httprouter.Router
 	// private
 	controllerMap     map[string]IController
+	scopedControllers map[string]bool
+	filterMap         map[string]Filter
+	handlerMap        map[string]http.Handler
 	templateMap       map[string]*templateInfo
 	templateFuncMap   template.FuncMap
+	builtinURLFunc    bool
 	basePath          string
 	entryHTTP         *appHTTP
 	entryHTTPS        *appHTTPS
@@ -78,6 +85,7 @@ func NewApp() *App {
 	app.Monitor = newMonitor(app)
 	app.Config = &AppConfig{}
 	app.TemplateProcessor = &defaultTemplateProcessor{}
+	app.RegisterFilter("problem", ProblemJSON)
 	app.ServeMux = httprouter.New()
 	app.ServeMux.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != "/" {
@@ -160,6 +168,7 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//
 	reqid := app.Monitor.openConnectionPaths.Add(r)
 	defer app.Monitor.openConnectionPaths.Remove(reqid)
+	defer app.recoverPanic(w, r)
 	//
 	routed := app.enroute(w, r)
 	//if routes didn't find anything
@@ -311,6 +320,73 @@ func (a *App) RegisterController(c IController) {
 	a.Logger.Printf("controller '%s' registered", name)
 }
 
+// RegisterScopedController registers a controller that is copied for every
+// request, so its fields (e.g. PageTitle, Layout or your own) can be set
+// per request without data races. The fields of c (e.g. app dependencies
+// like a database handle) are copied to every instance; Init runs only once,
+// on c.
+func (a *App) RegisterScopedController(c IController) {
+	a.RegisterController(c)
+	if a.scopedControllers == nil {
+		a.scopedControllers = make(map[string]bool)
+	}
+	a.scopedControllers[reflect.ValueOf(c).Elem().Type().Name()] = true
+}
+
+// getController returns the registered controller or, if it's request
+// scoped, a copy of it.
+func (a *App) getController(name string) IController {
+	c := a.controllerMap[name]
+	if c == nil || !a.scopedControllers[name] {
+		return c
+	}
+	v := reflect.ValueOf(c)
+	nv := reflect.New(v.Elem().Type())
+	nv.Elem().Set(v.Elem())
+	return nv.Interface().(IController)
+}
+
+// RegisterHandler registers a named http.Handler. Named handlers can be
+// used as route targets in the routes file:
+//
+//	GET /metrics handler:metrics
+func (a *App) RegisterHandler(name string, handler http.Handler) {
+	if a.handlerMap == nil {
+		a.handlerMap = make(map[string]http.Handler)
+	}
+	a.handlerMap[name] = handler
+}
+
+// Handle adds a route served by handler. Like controller routes, it runs
+// the filters, the middlewares and the TLS redirects. The route is kept
+// alongside the routes file when it's loaded or reloaded.
+func (a *App) Handle(method, path string, handler http.Handler) error {
+	if a.Router == nil {
+		a.Router = NewRouter(a, "memory")
+		a.Router.Routes = make([]*Route, 0)
+	}
+	route := NewRoute(method, path, routeHandlerPrefix, "", "memory", len(a.Router.memRoutes), false, a)
+	route.Handler = handler
+	return a.Router.addRoutes(route)
+}
+
+// Use adds middlewares that wrap every routed request (including the
+// filters).
+func (a *App) Use(mw ...Middleware) {
+	a.Middlewares = append(a.Middlewares, mw...)
+}
+
+// RegisterFilter registers a named filter. Named filters can be attached to
+// individual routes in the routes file:
+//
+//	GET /admin/users Admin.Users [auth,csrf]
+func (a *App) RegisterFilter(name string, filter Filter) {
+	if a.filterMap == nil {
+		a.filterMap = make(map[string]Filter)
+	}
+	a.filterMap[name] = filter
+}
+
 func (a *App) GetViewTemplate(localpath string) *template.Template {
 	if a.I18nProvider != nil {
 		localpath = localpath + "_" + a.Config.DefaultLanguage
@@ -355,23 +431,37 @@ func (a *App) GetLocalizedLayout(name string, w http.ResponseWriter, r *http.Req
 }
 
 func (a *App) DoHTTPError(w http.ResponseWriter, r *http.Request, err int) {
+	a.doHTTPError(w, r, err, nil)
+}
+
+// doHTTPError renders the error layout with herr (may be nil) as data.
+func (a *App) doHTTPError(w http.ResponseWriter, r *http.Request, err int, herr *HTTPError) {
 	// try the custom error function
 	if a.HTTPErrorFunc != nil {
 		a.HTTPErrorFunc(w, r, err)
 		return
 	}
+	// JSON API clients
+	if wantsProblem(r) {
+		writeProblem(w, newProblem(w, r, err, herr))
+		return
+	}
 	// try layouts
 	if lay := a.GetLocalizedLayout(fmt.Sprint(err), w, r); lay != nil {
 		w.WriteHeader(err)
-		lay.Execute(w, nil)
+		lay.Execute(w, herr)
 		return
 	}
 	if lay := a.GetLayout(fmt.Sprint(err)); lay != nil {
 		w.WriteHeader(err)
-		lay.Execute(w, nil)
+		lay.Execute(w, herr)
+		return
+	}
+	if herr != nil {
+		http.Error(w, herr.Message, err)
 		return
 	}
-	http.Error(w, httpErrorStrings[err], err)
+	http.Error(w, httpStatusText(err), err)
 	return
 }
 
@@ -452,18 +542,90 @@ func (a *App) loadRoutesNew() error {
 		}
 	}
 	if a.Router != nil {
-		a.Logger.Println("SKIPPING loadRoutesNew because the routes were added MANUALLY")
-		return nil
+		if a.Router.path != "memory" || a.Config == nil || len(a.Config.RoutesConfigPath) < 1 {
+			a.Logger.Println("SKIPPING loadRoutesNew because the routes were added MANUALLY")
+			return nil
+		}
+		// routes added with AddRouteLine or Handle come after the routes file
+		a.Router.refreshMutex.Lock()
+		a.Router.path = a.Config.RoutesConfigPath
+		a.Router.refreshMutex.Unlock()
+	} else {
+		a.Router = NewRouter(a, a.Config.RoutesConfigPath)
 	}
-	a.Router = NewRouter(a, a.Config.RoutesConfigPath)
 	err := a.Router.Refresh()
 	if err != nil {
 		return errors.New("loadRoutesNew a.Router.Refresh() " + err.Error())
 	}
 	a.Logger.Printf("%v routes loaded.\n", len(a.Router.Routes))
+	if a.Config.WatchRoutesFile && len(a.Config.RoutesConfigPath) > 0 {
+		a.watchRoutes()
+	}
 	return nil
 }
 
+// watchRoutes reloads the routes when the routes file (or any included
+// file) changes. The current routes are kept if the new ones are invalid.
+func (a *App) watchRoutes() {
+	fswatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		a.Logger.Printf("fsnotify error: %v\n", err.Error())
+		return
+	}
+	// editors may replace the file instead of writing to it,
+	// so the parent directories are watched
+	var files []string
+	watchFiles := func() {
+		files = a.Router.routeFiles()
+		for _, f := range files {
+			dir := filepath.Dir(f)
+			if err := fswatcher.Add(dir); err != nil {
+				a.Logger.Println("FSWATCH (routes) error", dir, err.Error())
+			}
+		}
+	}
+	watchFiles()
+	a.Logger.Println("FSWATCH (routes)", strings.Join(files, ", "))
+	reload := func() {
+		if err := a.Router.Refresh(); err != nil {
+			a.Logger.Println("FSWATCH routes reload failed; keeping the previous routes:", err.Error())
+			return
+		}
+		a.Logger.Printf("FSWATCH %v routes reloaded.\n", len(a.Router.Routes))
+		watchFiles()
+	}
+	reloadc := make(chan bool, 1)
+	go func() {
+		defer fswatcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case <-reloadc:
+				reload()
+			case evt := <-fswatcher.Events:
+				if StringIndexOf(files, filepath.Clean(evt.Name)) == -1 {
+					continue
+				}
+				if evt.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+					continue
+				}
+				// editors usually fire a few events per save
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(time.Millisecond*100, func() {
+					select {
+					case reloadc <- true:
+					default:
+					}
+				})
+			case err := <-fswatcher.Errors:
+				a.Logger.Println("FSWATCH (routes) error", err.Error())
+			}
+		}
+	}()
+}
+
 func (app *App) loadConfig() error {
 	// setup Random
 	src := rand.NewSource(time.Now().Unix())
@@ -558,28 +720,33 @@ func (a *App) AddRouteLine(line string) error {
 	if len(line) == 0 || line[0] == '#' {
 		return nil
 	}
-	method, path, action, fixedArgs, tls, found, _, _ := routeParseLine(line)
+	if isRouteDirective(line, "RESOURCE") {
+		rpath, controller, _, err := routeParseResourceLine(line)
+		if err != nil {
+			return err
+		}
+		routes := resourceRoutes(joinRoutePath(joinedPath, rpath), controller, "memory", len(a.Router.memRoutes), a)
+		return a.Router.addRoutes(routes...)
+	}
+	method, path, action, fixedArgs, filters, tls, found, _, _ := routeParseLine(line)
 	if !found {
 		return nil
 	}
-	// this will avoid accidental double forward slashes in a route.
-	// this also avoids pathtree freaking out and causing a runtime panic
-	// because of the double slashes
-	if strings.HasSuffix(joinedPath, "/") && strings.HasPrefix(path, "/") {
-		joinedPath = joinedPath[0 : len(joinedPath)-1]
-	}
-	path = strings.Join([]string{joinedPath, path}, "")
+	path = joinRoutePath(joinedPath, path)
 
-	route := NewRoute(method, path, action, fixedArgs, "memory", len(a.Router.Routes), tls, a)
-	a.Router.Routes = append(a.Router.Routes, route)
-	//
-	return a.Router.updateTree()
+	route := NewRoute(method, path, action, fixedArgs, "memory", len(a.Router.memRoutes), tls, a)
+	route.Filters = filters
+	return a.Router.addRoutes(route)
 }
 
 func (a *App) loadTemplates() error {
 	if a.templateFuncMap == nil {
 		a.templateFuncMap = make(template.FuncMap)
 	}
+	if _, ok := a.templateFuncMap["url"]; !ok {
+		a.AddTemplateFunc("url", a.URL)
+		a.builtinURLFunc = true
+	}
 	if a.TemplateProcessor == nil {
 		if a.Logger != nil {
 			a.Logger.Println("loadTemplates() TemplateProcessor was nil")
@@ -665,6 +832,9 @@ func (a *App) loadTemplates() error {
 				if err != nil {
 					return errors.New("loadTemplates template.New " + path + " templ.Parse " + err.Error())
 				}
+				if err := a.checkTemplateURLs(templ); err != nil {
+					return errors.New("loadTemplates " + path + " " + err.Error())
+				}
 				tplInfo.data = templ
 				a.templateMap[path] = tplInfo
 				bytesLoaded += len(bytes)
@@ -676,7 +846,7 @@ func (a *App) loadTemplates() error {
 						deps:       depList,
 					}
 					locPName := path + "_" + lcv
-					templ := template.New(locPName)
+					templ := template.New(locPName).Funcs(a.templateFuncMap)
 					if ext == ".pug" || ext == ".jade" {
 						// it's a jade
 						jadef, err := jade.Parse(locPName, bytes)
@@ -690,6 +860,9 @@ func (a *App) loadTemplates() error {
 					if err != nil {
 						return errors.New("loadTemplates " + locPName + " templ.Parse LocalizeTemplate " + err.Error())
 					}
+					if err := a.checkTemplateURLs(templ); err != nil {
+						return errors.New("loadTemplates " + locPName + " " + err.Error())
+					}
 					tplInfo.data = templ
 					a.templateMap[locPName] = tplInfo
 					bytesLoaded += len(bytes)
@@ -749,6 +922,10 @@ func (a *App) loadTemplates() error {
 										a.Logger.Println("FSWATCH loadTemplates template.New", path, "templ.Parse", err.Error())
 										break
 									}
+									if err := a.checkTemplateURLs(templ); err != nil {
+										a.Logger.Println("FSWATCH loadTemplates", path, err.Error())
+										break
+									}
 									tplInfo.data = templ
 									a.templateMap[path] = tplInfo
 									a.Logger.Println("FSWATCH reloaded template", path)
@@ -776,7 +953,7 @@ func (a *App) loadTemplates() error {
 											deps:       depList,
 										}
 										locPName := path + "_" + lcv
-										templ := template.New(locPName)
+										templ := template.New(locPName).Funcs(a.templateFuncMap)
 										if ext == ".pug" || ext == ".jade" {
 											// it's a jade
 											jadef, err := jade.Parse(locPName, bytes)
@@ -792,6 +969,10 @@ func (a *App) loadTemplates() error {
 											a.Logger.Println("FSWATCH loadTemplates", locPName, "templ.Parse LocalizeTemplate", err.Error())
 											break
 										}
+										if err := a.checkTemplateURLs(templ); err != nil {
+											a.Logger.Println("FSWATCH loadTemplates", locPName, err.Error())
+											break
+										}
 										tplInfo.data = templ
 										a.templateMap[locPName] = tplInfo
 										a.Logger.Println("FSWATCH reloaded template", locPName)
@@ -919,6 +1100,24 @@ func (app *App) enroute(w http.ResponseWriter, r *http.Request) bool {
 			r.Method = "WS"
 		}
 		match := app.Router.Route(r)
+		if match == nil && r.Method != "WS" {
+			// the path may exist under other methods
+			if allowed := app.Router.AllowedMethods(r); allowed != nil && !app.serveMuxHandles(r) {
+				// answered like a handler route, so app middlewares and
+				// filters (e.g. CORS) run first
+				match = &RouteMatch{
+					Action: routeHandlerPrefix + "allowed",
+					Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("Allow", strings.Join(allowed, ", "))
+						if r.Method == http.MethodOptions {
+							w.WriteHeader(http.StatusNoContent)
+							return
+						}
+						app.DoHTTPError(w, r, 405)
+					}),
+				}
+			}
+		}
 		if match != nil {
 			if match.Action == "404" {
 				//TODO: clean flash
@@ -926,8 +1125,14 @@ func (app *App) enroute(w http.ResponseWriter, r *http.Request) bool {
 				return true
 			}
 			// enroute based on method
-			c := app.controllerMap[match.ControllerName]
-			if c == nil {
+			c := app.getController(match.ControllerName)
+			if strings.HasPrefix(match.Action, routeHandlerPrefix) {
+				if match.Handler == nil {
+					app.Logger.Printf("[FATAL] Handler '%s' is not registered!\n", match.Action[len(routeHandlerPrefix):])
+					app.DoHTTPError(w, r, 501)
+					return true
+				}
+			} else if c == nil {
 				// Internal Server Error
 				app.Logger.Fatalf("Controller '%s' is not registered!\n", match.ControllerName)
 				app.DoHTTPError(w, r, 501)
@@ -1007,31 +1212,105 @@ func (app *App) enroute(w http.ResponseWriter, r *http.Request) bool {
 				inObj.Wsock = conn
 			}
 
-			return app.handleReq(c, inObj)
+			return app.handleReq(c, inObj, match)
 		}
 	}
 	return false
 }
 
-func (app *App) handleReq(c IController, in *In) bool {
+// serveMuxHandles checks if ServeMux has a handler for the request
+// method and path.
+func (app *App) serveMuxHandles(r *http.Request) bool {
+	if app.ServeMux == nil {
+		return false
+	}
+	h, _, _ := app.ServeMux.Lookup(r.Method, r.URL.Path)
+	return h != nil
+}
+
+func (app *App) handleReq(c IController, in *In, match *RouteMatch) bool {
 	defer in.closeall()
+	defer func() {
+		// keep the stack and the route for recoverPanic
+		if rec := recover(); rec != nil {
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			// the error page is not compressed (closeall runs first)
+			uncompressed(in.W)
+			panic(&actionPanic{rec, debug.Stack(), match})
+		}
+	}()
+	// app middlewares wrap the filters; controller middlewares wrap the action
+	var cmws []Middleware
+	if c != nil {
+		cmws = c.getMiddlewares()
+	}
+	action := chainMiddleware(func(in *In) {
+		if match.Handler != nil {
+			match.Handler.ServeHTTP(in.W, in.R)
+			return
+		}
+		app.runAction(c, in, match)
+	}, cmws)
+	if c != nil && c.problemJSON() {
+		ProblemJSON(in)
+	}
+	if app.Config.MaxBodySize > 0 && in.R != nil {
+		limitBody(in.W, in.R, app.Config.MaxBodySize)
+	}
+	h := chainMiddleware(func(in *In) {
+		if app.runFilters(in, match) {
+			action(in)
+		}
+	}, app.Middlewares)
+	h(in)
+	if in.session != nil {
+		in.session.Flash.Clear()
+	} // else {
+	//	in.Session().Flash.Clear()
+	//}
+	return true
+}
+
+// runFilters runs the app filters and the filters declared on the route.
+// It returns false if a filter stopped the request.
+func (app *App) runFilters(in *In, match *RouteMatch) bool {
 	// run all filters
 	if app.Filters != nil {
 		for _, filter := range app.Filters {
 			if ok := filter(in); !ok {
-				return true
+				return false
 			}
 		}
 	}
+	// run the filters declared on the route
+	for _, name := range match.Filters {
+		filter, ok := app.filterMap[name]
+		if !ok {
+			app.Logger.Printf("[FATAL] Filter '%s' is not registered!\n", name)
+			app.DoHTTPError(in.W, in.R, 501)
+			return false
+		}
+		if ok := filter(in); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// runAction runs the controller pre filter and the action, and renders
+// the output.
+func (app *App) runAction(c IController, in *In, match *RouteMatch) {
 	// run controller pre filter
 	// you may want to run something before all the other methods, this is where you do it
 	prec := c.PreFilter(in)
 	if prec == nil {
-		return true
+		return
 	} else {
 		if prec.kind != outPre {
 			prec.Render(in.W)
-			return true
+			return
 		}
 	}
 
@@ -1043,29 +1322,32 @@ func (app *App) handleReq(c IController, in *In) bool {
 	if c == nil {
 		log.Println("[FATAL] Controller '%s %s' is null.", in.controllerName, in.methodName)
 		app.DoHTTPError(in.W, in.R, 501)
-		return true
+		return
 	}
 	rVal, rValOK := c.getMethod(controllerMethod)
 	if !rValOK {
 		log.Println("[FATAL] Controller '%s' does not contain a method '%s', or it's not valid.", in.controllerName, in.methodName)
 		app.DoHTTPError(in.W, in.R, 501)
-		return true
+		return
+	}
+	// bind the typed args
+	args, err := in.bindActionArgs(rVal, match.ArgNames, match.FixedParams)
+	if err != nil {
+		app.Logvln("bad request:", in.controllerName, in.methodName, err.Error())
+		app.DoHTTPError(in.W, in.R, 400)
+		return
 	}
 	// finally run it
-	inz := make([]reflect.Value, 2)
+	inz := make([]reflect.Value, 2, 2+len(args))
 	inz[0] = reflect.ValueOf(c)
 	inz[1] = reflect.ValueOf(in)
+	inz = append(inz, args...)
 	out := rVal.Val.Call(inz)
-	o0, _ := (out[0].Interface()).(*Out)
-	if o0 != nil {
+	if len(out) == 2 && !out[1].IsNil() {
+		app.HandleError(in, out[1].Interface().(error))
+	} else if o0, _ := (out[0].Interface()).(*Out); o0 != nil {
 		o0.Render(in.W)
 	}
-	if in.session != nil {
-		in.session.Flash.Clear()
-	} // else {
-	//	in.Session().Flash.Clear()
-	//}
-	return true
 }
 
 func (a *App) registerControllerMethods(c IController) {
@@ -1073,6 +1355,7 @@ func (a *App) registerControllerMethods(c IController) {
 	pt := v.Type()
 	inType := reflect.TypeOf((*In)(nil)).Elem()
 	outType := reflect.TypeOf((*Out)(nil)).Elem()
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
 	// mmap
 	n := pt.NumMethod()
 	for i := 0; i < n; i++ {
@@ -1080,15 +1363,15 @@ func (a *App) registerControllerMethods(c IController) {
 		name := m.Name
 		// don't register known methods
 		switch name {
-		case "Init", "PreFilter":
+		case "Init", "PreFilter", "Use":
 			continue
 		case "registerMethod", "getMethod":
 			continue
 		}
 		mt := m.Type
-		// outp must be 1 (interface{})
+		// outp must be 1 (*Out) or 2 (*Out, error)
 		outp := mt.NumOut()
-		if outp != 1 {
+		if outp != 1 && (outp != 2 || mt.Out(1) != errorType) {
 			continue
 		}
 		//a.Logger.Printf("Method: %s, IN:%d, OUT:%d", name, inpt, outp)
@@ -1102,8 +1385,8 @@ func (a *App) registerControllerMethods(c IController) {
 		// in amount is (c *Obj) (var1 type, var2 type)
 		//               # 1 #     # 2 #      # 3 #
 		inpt := mt.NumIn()
-		// input must be 2 (controller + 1)
-		if inpt != 2 {
+		// input must be at least 2 (controller + in)
+		if inpt < 2 {
 			continue
 		}
 		if mt.In(1).Kind() != reflect.Ptr {
@@ -1114,6 +1397,18 @@ func (a *App) registerControllerMethods(c IController) {
 			//a.Logger.Println(mt.In(1).Elem().String(), "is not", inType.String())
 			continue
 		}
+		// typed args (e.g. id int64, page int) are bound from the request
+		bindable := true
+		for j := 2; j < inpt; j++ {
+			if !isBindableKind(mt.In(j).Kind()) {
+				bindable = false
+				break
+			}
+		}
+		if !bindable || mt.IsVariadic() {
+			a.Logger.Printf("controller method '%s' skipped: its args cannot be bound", name)
+			continue
+		}
 		c.registerMethod(name, m.Func)
 	}
 }
@@ -50,6 +50,7 @@ type AppConfig struct {
 	PublicFolderPath string   `yaml:"PublicFolderPath"`
 
 	WatchViewsFolder bool `yaml:"WatchViewsFolder"`
+	WatchRoutesFile  bool `yaml:"WatchRoutesFile"` // reloads RoutesConfigPath (and includes) on change
 
 	StaticAccessLog  bool
 	DynamicAccessLog bool
@@ -60,6 +61,11 @@ type AppConfig struct {
 
 	StaticIndexFiles []string `yaml:"StaticIndexFiles"`
 
+	// Uploads (0 is unlimited; routes can use UploadLimits)
+	MaxBodySize        int64    `yaml:"MaxBodySize"`        // bytes of a request body
+	MaxUploadFileSize  int64    `yaml:"MaxUploadFileSize"`  // bytes of each uploaded file
+	UploadAllowedTypes []string `yaml:"UploadAllowedTypes"` // sniffed MIME types, e.g. image/png, image/*
+
 	//Gracefully restarts if enabled
 	GracefulRestart bool
 }
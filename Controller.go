@@ -13,6 +13,8 @@ type IController interface {
 	PreFilter(in *In) *Out
 	registerMethod(name string, method reflect.Value)
 	getMethod(name string) (controllerMethod, bool)
+	getMiddlewares() []Middleware
+	problemJSON() bool
 }
 
 type PageContent struct {
@@ -47,7 +49,9 @@ type Controller struct {
 	PageTitle     string
 	Layout        string
 	ContentType   string
+	ProblemJSON   bool // errors are application/problem+json responses
 	customMethods map[string]controllerMethod
+	middlewares   []Middleware
 }
 
 const (
@@ -56,7 +60,8 @@ const (
 )
 
 type controllerMethod struct {
-	Val reflect.Value
+	Val  reflect.Value
+	Args []reflect.Type // typed args after *In (e.g. id int64, page int)
 }
 
 func (c *Controller) GetPageTitle() string {
@@ -89,7 +94,13 @@ func (c *Controller) registerMethod(name string, method reflect.Value) {
 	if c.customMethods == nil {
 		c.customMethods = make(map[string]controllerMethod, 0)
 	}
-	c.customMethods[name] = controllerMethod{method}
+	// func(c *Controller, in *In, args...) *Out (or (*Out, error))
+	mt := method.Type()
+	args := make([]reflect.Type, 0, mt.NumIn()-2)
+	for i := 2; i < mt.NumIn(); i++ {
+		args = append(args, mt.In(i))
+	}
+	c.customMethods[name] = controllerMethod{method, args}
 }
 func (c *Controller) getMethod(name string) (controllerMethod, bool) {
 	if c.customMethods == nil {
@@ -98,3 +109,17 @@ func (c *Controller) getMethod(name string) (controllerMethod, bool) {
 	val, ok := c.customMethods[name]
 	return val, ok
 }
+
+// Use adds middlewares that wrap the actions of this controller (including
+// PreFilter). Call it in Init.
+func (c *Controller) Use(mw ...Middleware) {
+	c.middlewares = append(c.middlewares, mw...)
+}
+
+func (c *Controller) getMiddlewares() []Middleware {
+	return c.middlewares
+}
+
+func (c *Controller) problemJSON() bool {
+	return c.ProblemJSON
+}
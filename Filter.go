@@ -3,6 +3,7 @@ package goboots
 import (
 	"compress/gzip"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 )
@@ -24,6 +25,32 @@ func (w gzipRespWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush flushes the gzipped data to the client (needed by streams like
+// OutputSSE).
+func (w gzipRespWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// uncompressed discards the gzip writer of w (if any) and returns the
+// writer it wraps, so the response is sent as is.
+func uncompressed(w http.ResponseWriter) http.ResponseWriter {
+	gzr, ok := w.(*gzipRespWriter)
+	if !ok {
+		return w
+	}
+	if gz, ok := gzr.Writer.(*gzip.Writer); ok {
+		// closing it (In.closeall) writes nothing
+		gz.Reset(ioutil.Discard)
+	}
+	gzr.ResponseWriter.Header().Del("Content-Encoding")
+	return gzr.ResponseWriter
+}
+
 // based on https://gist.github.com/the42/1956518
 func CompressFilter(in *In) bool {
 	if in.hijacked {
@@ -4,19 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
-	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -30,6 +33,11 @@ const (
 	outString       = 5
 	outBytes        = 6
 	outFile         = 7
+	outRedirect     = 8
+	outSSE          = 9
+	outEncoded      = 10
+	outCSV          = 11
+	outNDJSON       = 12
 )
 
 type InFunc func(in *In)
@@ -243,6 +251,25 @@ func (in *In) OutputLayTpl(layout, tplPath string) *Out {
 	return in.outputTpl(tplPath, layout)
 }
 
+// newOut creates an output of the given kind and runs the beforeoutput
+// functions.
+func (in *In) newOut(kind int) *Out {
+	o := &Out{
+		app: in.App,
+		in:  in,
+	}
+	o.defers = in.defers
+	if in.R != nil {
+		o.ctx = in.R.Context()
+	}
+	// exec all beforeoutput functions
+	for _, f := range in.beforeoutput {
+		f(in)
+	}
+	o.kind = kind
+	return o
+}
+
 func (in *In) OutputLay(layout string) *Out {
 	return in.outputTpl("", layout)
 }
@@ -250,6 +277,7 @@ func (in *In) OutputLay(layout string) *Out {
 func (in *In) outputTpl(tplPath, customLayout string) *Out {
 	o := &Out{
 		app: in.App,
+		in:  in,
 	}
 	o.defers = in.defers
 	if in.R != nil {
@@ -261,7 +289,9 @@ func (in *In) outputTpl(tplPath, customLayout string) *Out {
 	}
 	in.Content.MergeNoOverwrite(in.LayoutContent.All())
 	if len(tplPath) > 0 {
-		in.LayoutContent.Set("Content", in.OutputSoloTpl(tplPath).String())
+		var buffer bytes.Buffer
+		o.err = in.OutputSoloTpl(tplPath).execute(&buffer)
+		in.LayoutContent.Set("Content", buffer.String())
 	}
 	if v, ok := in.LayoutContent.GetString2("Title"); ok {
 		in.LayoutContent.Set("Title", in.GlobalTitle+v)
@@ -297,6 +327,7 @@ func (in *In) outputTpl(tplPath, customLayout string) *Out {
 func (in *In) OutputSoloTpl(tplPath string) *Out {
 	o := &Out{
 		app: in.App,
+		in:  in,
 	}
 	o.defers = in.defers
 	if in.R != nil {
@@ -395,22 +426,70 @@ func (in *In) OutputBytes(b []byte) *Out {
 }
 
 func (in *In) OutputFile(name string) *Out {
-	o := &Out{
-		app: in.App,
+	o := in.newOut(outFile)
+	o.contentStr = name
+	return o
+}
+
+// OutputAttachment serves a file (like OutputFile) that the browser
+// downloads as downloadName.
+func (in *In) OutputAttachment(name, downloadName string) *Out {
+	o := in.OutputFile(name)
+	o.attachment = downloadName
+	return o
+}
+
+// OutputReader serves content like OutputFile. The name is used to detect
+// the Content-Type (by its extension) and modtime (if not zero) to answer
+// conditional requests.
+func (in *In) OutputReader(content io.ReadSeeker, name string, modtime time.Time) *Out {
+	o := in.OutputFile(name)
+	o.reader = content
+	o.modtime = modtime
+	return o
+}
+
+// RedirectToAction redirects (302) to the URL of a controller action,
+// built by the reverse router (e.g. "Posts.Show", map[string]string{"id": "5"}).
+// It panics if no route leads to the action, so broken links show up
+// during development.
+func (in *In) RedirectToAction(action string, params map[string]string) *Out {
+	if in.App.Router == nil || !in.App.Router.HasAction(action) {
+		panic("goboots: RedirectToAction: no route for action " + action)
 	}
-	o.defers = in.defers
-	if in.R != nil {
-		o.ctx = in.R.Context()
+	def := in.App.Router.Reverse(action, params)
+	if def == nil {
+		panic("goboots: RedirectToAction: no route for action " + action)
 	}
-	// exec all beforeoutput functions
-	for _, f := range in.beforeoutput {
-		f(in)
+	return in.Redirect(def.Url, http.StatusFound)
+}
+
+// Redirect redirects to url with code (302 if 0). Flash messages can be
+// added to the output:
+//
+//	return in.Redirect("/posts", 303).Flash("notice", "Post created")
+func (in *In) Redirect(url string, code int) *Out {
+	if code == 0 {
+		code = http.StatusFound
 	}
-	o.kind = outFile
-	o.contentStr = name
+	o := in.newOut(outRedirect)
+	o.contentStr = url
+	o.status = code
 	return o
 }
 
+// RedirectBack redirects (302) to the referring page, or to / if the
+// Referer header is missing or points to another host.
+func (in *In) RedirectBack() *Out {
+	back := "/"
+	if in.R != nil {
+		if ref, err := url.Parse(in.R.Referer()); err == nil && len(ref.Path) > 0 && (ref.Host == "" || ref.Host == in.R.Host) {
+			back = ref.RequestURI()
+		}
+	}
+	return in.Redirect(back, http.StatusFound)
+}
+
 func (in *In) Continue() *Out {
 	o := &Out{
 		app: in.App,
@@ -453,32 +532,23 @@ func (in *In) SprintFormVals(format string, keys ...string) string {
 	return fmt.Sprintf(format, ks...)
 }
 
+// FormFile returns the first file of the form field key. Use Upload to
+// check the file size and type.
 func (in *In) FormFile(key string) (multipart.File, *multipart.FileHeader, error) {
+	if strings.HasPrefix(in.R.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := parseMultipartForm(in.R); err != nil {
+			return nil, nil, err
+		}
+	}
 	return in.R.FormFile(key)
 }
 
 func (inbw *InBodyWrapper) UnmarshalJSON(v interface{}) error {
-	if inbw.R.Body == nil {
-		return errors.New("request body is null")
-	}
-	defer inbw.R.Body.Close()
-	bs, err := ioutil.ReadAll(inbw.R.Body)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(bs, v)
+	return inbw.unmarshal(GetCodec("application/json"), v)
 }
 
 func (inbw *InBodyWrapper) UnmarshalXML(v interface{}) error {
-	if inbw.R.Body == nil {
-		return errors.New("request body is null")
-	}
-	defer inbw.R.Body.Close()
-	bs, err := ioutil.ReadAll(inbw.R.Body)
-	if err != nil {
-		return err
-	}
-	return xml.Unmarshal(bs, v)
+	return inbw.unmarshal(GetCodec("application/xml"), v)
 }
 
 type Out struct {
@@ -490,6 +560,221 @@ type Out struct {
 	defers       []func()
 	ctx          context.Context
 	app          *App
+	status       int
+	headers      http.Header
+	cookies      []*http.Cookie
+	in           *In
+	events       <-chan Event
+	heartbeat    time.Duration
+	reader       io.ReadSeeker
+	modtime      time.Time
+	attachment   string
+	err          error // e.g. the content template of a layout failed
+	codec        Codec
+	rows         RowIterator
+	values       ValueIterator
+}
+
+// Status sets the HTTP status code of the response (200 if not set).
+//
+//	return in.OutputJSON(post).Status(201).Header("Location", url)
+func (o *Out) Status(code int) *Out {
+	o.status = code
+	return o
+}
+
+// Header adds a response header. It's applied before the defaults of
+// Render (e.g. it can override the Content-Type).
+func (o *Out) Header(key, value string) *Out {
+	if o.headers == nil {
+		o.headers = make(http.Header)
+	}
+	o.headers.Add(key, value)
+	return o
+}
+
+// Cookie adds a Set-Cookie header to the response.
+func (o *Out) Cookie(cookie *http.Cookie) *Out {
+	o.cookies = append(o.cookies, cookie)
+	return o
+}
+
+// renderFile serves the file (or the reader) like App.ServeFile, with
+// range and conditional requests support.
+func (o *Out) renderFile(w http.ResponseWriter) {
+	var r *http.Request
+	if o.in != nil {
+		r = o.in.R
+	}
+	if r == nil {
+		r = &http.Request{Method: http.MethodGet, Header: make(http.Header)}
+	}
+	name, modtime, content := o.contentStr, o.modtime, o.reader
+	if content == nil {
+		f, err := os.Open(o.contentStr)
+		if err != nil {
+			msg, code := toHTTPError(err)
+			http.Error(w, msg, code)
+			return
+		}
+		defer f.Close()
+		d, err := f.Stat()
+		if err != nil {
+			msg, code := toHTTPError(err)
+			http.Error(w, msg, code)
+			return
+		}
+		if d.IsDir() {
+			http.Error(w, httpStatusText(404), 404)
+			return
+		}
+		name, modtime, content = d.Name(), d.ModTime(), f
+		if len(w.Header().Get("Etag")) < 1 {
+			// like nginx: modification time and size
+			w.Header().Set("Etag", fmt.Sprintf(`"%x-%x"`, d.ModTime().Unix(), d.Size()))
+		}
+	}
+	if len(o.attachment) > 0 {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": o.attachment}))
+	}
+	// ranges and lengths must be of the file bytes, so files are not
+	// compressed
+	w = uncompressed(w)
+	if o.status != 0 && o.status != http.StatusOK {
+		// ranges and conditional requests are only for 200 responses
+		if len(w.Header().Get("Content-Type")) < 1 {
+			if ctype := mime.TypeByExtension(filepath.Ext(name)); len(ctype) > 0 {
+				w.Header().Set("Content-Type", ctype)
+			}
+		}
+		o.writeStatus(w)
+		io.Copy(w, content)
+		return
+	}
+	http.ServeContent(w, r, name, modtime, content)
+}
+
+// Flash saves a flash message in the session, so it's available on the
+// next request (e.g. the page of a redirect).
+func (o *Out) Flash(key string, value interface{}) *Out {
+	if o.in == nil {
+		panic("goboots: Flash needs an output created by In (e.g. in.Redirect)")
+	}
+	if s := o.in.Session(); s != nil {
+		s.keepFlash(key, value)
+	}
+	return o
+}
+
+// writeHeaders applies the headers and the cookies of o.
+func (o *Out) writeHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range o.headers {
+		h[k] = v
+	}
+	for _, c := range o.cookies {
+		http.SetCookie(w, c)
+	}
+	if _, ok := w.(*gzipRespWriter); ok {
+		// the gzipped length is unknown
+		h.Del("Content-Length")
+	}
+}
+
+// clearHeaders removes the headers and cookies of writeHeaders, so they
+// are not sent with an error response.
+func (o *Out) clearHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	for k := range o.headers {
+		h.Del(k)
+	}
+	if len(o.cookies) < 1 {
+		return
+	}
+	cookies := h["Set-Cookie"][:0]
+	for _, v := range h["Set-Cookie"] {
+		keep := true
+		for _, c := range o.cookies {
+			if v == c.String() {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			cookies = append(cookies, v)
+		}
+	}
+	if len(cookies) < 1 {
+		h.Del("Set-Cookie")
+		return
+	}
+	h["Set-Cookie"] = cookies
+}
+
+// renderBufferPool holds the buffers of the template outputs.
+var renderBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func putRenderBuffer(buf *bytes.Buffer) {
+	// don't keep huge pages around
+	if buf.Cap() <= 1<<20 {
+		renderBufferPool.Put(buf)
+	}
+}
+
+// execute executes the template of a template output.
+func (o *Out) execute(w io.Writer) error {
+	if o.err != nil {
+		return o.err
+	}
+	if o.tpl == nil {
+		return errors.New("template not found")
+	}
+	return o.tpl.Execute(w, o.contentObj)
+}
+
+// renderError logs err and answers with a 500.
+func (o *Out) renderError(w http.ResponseWriter, err error) {
+	o.clearHeaders(w)
+	if o.app == nil {
+		DefaultLogger().Println("Render error:", err.Error())
+		http.Error(w, httpStatusText(500), 500)
+		return
+	}
+	o.app.Logger.Println("Render error:", err.Error())
+	if o.in != nil && o.in.R != nil {
+		if o.app.Config != nil && o.app.Config.Verbose {
+			p := &actionPanic{value: err, stack: debug.Stack()}
+			if len(o.in.methodName) > 0 {
+				p.match = &RouteMatch{ControllerName: o.in.controllerName, MethodName: o.in.methodName, Params: o.in.Params}
+			}
+			o.app.renderPanicPage(w, o.in.R, "render error", p)
+			return
+		}
+		o.app.DoHTTPError(w, o.in.R, 500)
+		return
+	}
+	http.Error(w, httpStatusText(500), 500)
+}
+
+// writeBody writes a buffered body along with its Content-Length.
+func (o *Out) writeBody(w http.ResponseWriter, b []byte) {
+	if _, gz := w.(*gzipRespWriter); !gz && len(w.Header().Get("Content-Encoding")) < 1 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	}
+	o.writeStatus(w)
+	w.Write(b)
+}
+
+// writeStatus writes the status code (if set). It must be called after
+// the headers are set and before the body is written.
+func (o *Out) writeStatus(w http.ResponseWriter) {
+	if o.status != 0 {
+		w.WriteHeader(o.status)
+	}
 }
 
 func (o *Out) IsContinue() bool {
@@ -521,82 +806,80 @@ func (o *Out) Render(w http.ResponseWriter) {
 		}
 	}
 	switch o.kind {
+	case outJSON, outXML, outTemplateSolo, outTemplate, outEncoded:
+		// written by renderBody, once the body is built
+	default:
+		o.writeHeaders(w)
+	}
+	switch o.kind {
 	case outJSON:
-		defer func() {
-			if r := recover(); r != nil {
-				DefaultLogger().Println("outJSON recover", r, o.contentObj)
-			}
-		}()
-		if len(w.Header().Get("Content-Type")) < 1 {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		}
-		w.Write(o.mustb(json.Marshal(o.contentObj)))
+		b, err := marshalCodec("application/json", o.contentObj)
+		o.renderBody(w, "application/json; charset=utf-8", b, err)
 	case outXML:
-		if len(w.Header().Get("Content-Type")) < 1 {
-			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		}
-		w.Write(o.mustb(xml.Marshal(o.contentObj)))
+		b, err := marshalCodec("application/xml", o.contentObj)
+		o.renderBody(w, "application/xml; charset=utf-8", b, err)
 	case outTemplateSolo, outTemplate:
-		if len(w.Header().Get("Content-Type")) < 1 {
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		}
-		if err := o.tpl.Execute(w, o.contentObj); err != nil && o.app != nil {
-			o.app.Logger.Println("tpl Execute error:", err.Error())
-		}
+		// render to a buffer, so a failure can still become a 500
+		buf := renderBufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer putRenderBuffer(buf)
+		err := o.execute(buf)
+		o.renderBody(w, "text/html; charset=utf-8", buf.Bytes(), err)
 	case outString:
 		if len(w.Header().Get("Content-Type")) < 1 {
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		}
-		w.Write([]byte(o.contentStr))
+		o.writeBody(w, []byte(o.contentStr))
 	case outBytes:
 		if len(w.Header().Get("Content-Type")) < 1 {
 			w.Header().Set("Content-Type", "application/octet-stream; charset=utf-8")
 		}
-		w.Write(o.contentBytes)
+		o.writeBody(w, o.contentBytes)
 	case outFile:
-		name := o.contentStr
-		if len(w.Header().Get("Content-Type")) < 1 {
-			f, err := os.Open(name)
-			if err != nil {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				w.Write([]byte(err.Error()))
-			} else {
-				defer f.Close()
-				firstBytes := make([]byte, 1024)
-				bytesRead, err := f.Read(firstBytes)
-				if err != nil {
-					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-					w.Write([]byte(err.Error()))
-				} else {
-					w.Header().Set("Content-Type", http.DetectContentType(firstBytes))
-					w.Write(firstBytes[:bytesRead])
-					if bytesRead == 1024 {
-						io.Copy(w, f)
-					}
-				}
-			}
-		} else {
-			f, err := os.Open(name)
-			if err != nil {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				w.Write([]byte(err.Error()))
-			} else {
-				defer f.Close()
-				io.Copy(w, f)
-			}
-		}
+		o.renderFile(w)
+	case outRedirect:
+		w.Header().Set("Location", o.contentStr)
+		w.WriteHeader(o.status)
+	case outSSE:
+		o.renderSSE(w)
+	case outCSV:
+		o.renderCSV(w)
+	case outNDJSON:
+		o.renderNDJSON(w)
+	case outEncoded:
+		b, err := o.codec.Marshal(o.contentObj)
+		o.renderBody(w, o.contentStr, b, err)
+	}
+}
+
+// renderBody writes the Out headers and a buffered body (e.g. marshaled
+// JSON), or answers 500 if the body couldn't be built.
+func (o *Out) renderBody(w http.ResponseWriter, ctype string, b []byte, err error) {
+	if err != nil {
+		o.renderError(w, err)
+		return
+	}
+	o.writeHeaders(w)
+	if len(w.Header().Get("Content-Type")) < 1 {
+		w.Header().Set("Content-Type", ctype)
 	}
+	o.writeBody(w, b)
 }
 
 func (o *Out) String() string {
 	switch o.kind {
 	case outJSON:
-		return string(o.mustb(json.Marshal(o.contentObj)))
+		return string(o.mustb(marshalCodec("application/json", o.contentObj)))
 	case outXML:
-		return string(o.mustb(xml.Marshal(o.contentObj)))
+		return string(o.mustb(marshalCodec("application/xml", o.contentObj)))
 	case outTemplateSolo, outTemplate:
 		var buffer bytes.Buffer
-		o.tpl.Execute(&buffer, o.contentObj)
+		if err := o.execute(&buffer); err != nil {
+			if o.app != nil {
+				o.app.Logger.Println("tpl Execute error:", err.Error())
+			}
+			return ""
+		}
 		return buffer.String()
 	case outString:
 		return o.contentStr
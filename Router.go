@@ -34,23 +34,32 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type Route struct {
 	Method         string   // e.g. GET
-	Path           string   // e.g. /app/:id
+	Path           string   // e.g. /app/:id (constraints are removed)
 	Action         string   // e.g. "Application.ShowApp", "404"
 	ControllerName string   // e.g. "Application", ""
 	MethodName     string   // e.g. "ShowApp", ""
 	FixedParams    []string // e.g. "arg1","arg2","arg3" (CSV formatting)
 	TreePath       string   // e.g. "/GET/app/:id"
 	TLSOnly        bool
+	Filters        []string     // e.g. "auth","csrf" (names registered with App.RegisterFilter)
+	Host           string       // e.g. "api.example.com", ":tenant.example.com", "" (any host)
+	ArgNames       []string     // e.g. "","id","page" for Posts.Show("fixed", :id, :page); "" uses FixedParams
+	Handler        http.Handler // set by App.Handle; handler:name routes use App.RegisterHandler
 
 	routesPath string // e.g. /Users/robfig/gocode/src/myapp/conf/routes
 	line       int    // e.g. 3
 	app        *App
+	args       []*arg // wildcards of TreePath, in order
+	pathErr    error  // invalid constraints
 }
 
 type RouteMatch struct {
@@ -60,16 +69,183 @@ type RouteMatch struct {
 	FixedParams    []string
 	Params         Params // e.g. {id: 123}
 	TLSOnly        bool
+	Filters        []string // e.g. auth, csrf
+	ArgNames       []string // e.g. id, page
+	Handler        http.Handler
 }
 
 var routeMatchNotFound = &RouteMatch{Action: "404"}
 
+// routeHandlerPrefix is the action prefix of routes that are served by
+// a registered http.Handler (e.g. GET /metrics handler:metrics).
+const routeHandlerPrefix = "handler:"
+
 type arg struct {
 	name       string
 	index      int
 	constraint *regexp.Regexp
 }
 
+// routeConstraintTypes are the named constraints that can be used
+// instead of a regular expression (e.g. /users/:id<int>).
+var routeConstraintTypes = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uint":  `[0-9]+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"slug":  `[a-z0-9]+(?:-[a-z0-9]+)*`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// parseRoutePath removes the constraints of a path like
+// /users/:id<\d+>/posts/:slug<slug>.json and returns the clean path along
+// with the constraint of every wildcard (nil if unconstrained).
+func parseRoutePath(path string) (string, []*regexp.Regexp, error) {
+	var b strings.Builder
+	constraints := make([]*regexp.Regexp, 0)
+	segStart := 0
+	wildcard, constrained := false, false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '/':
+			if wildcard && !constrained {
+				constraints = append(constraints, nil)
+			}
+			segStart = i + 1
+			wildcard, constrained = false, false
+		case (c == ':' || c == '*') && i == segStart:
+			wildcard = true
+		case c == '<' && wildcard && !constrained:
+			// constraints may contain / (e.g. *path<[a-z/]+>)
+			end := routeConstraintEnd(path, i)
+			if end == -1 {
+				return "", nil, errors.New("constraint of `" + path[segStart:] + "` is not closed")
+			}
+			expr := path[i+1 : end]
+			if named, ok := routeConstraintTypes[expr]; ok {
+				expr = named
+			}
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return "", nil, errors.New("invalid constraint of `" + path[segStart:end+1] + "`: " + err.Error())
+			}
+			constraints = append(constraints, re)
+			constrained = true
+			i = end
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if wildcard && !constrained {
+		constraints = append(constraints, nil)
+	}
+	return b.String(), constraints, nil
+}
+
+// routeConstraintEnd returns the index of the > that closes the < at
+// begin (-1 if it's not closed). Nested <> (e.g. (?P<name>...)) and
+// escaped characters are skipped.
+func routeConstraintEnd(path string, begin int) int {
+	depth := 0
+	for i := begin; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '<':
+			depth++
+		case '>':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// treeArgs lists the wildcards of a tree path (pathtree drops the
+// extension of the last one).
+func treeArgs(tpath string, constraints []*regexp.Regexp) []*arg {
+	args := make([]*arg, 0)
+	for _, el := range strings.Split(tpath, "/") {
+		if len(el) < 1 || (el[0] != ':' && el[0] != '*') {
+			continue
+		}
+		name := el[1:]
+		if dot := strings.LastIndex(name, "."); dot != -1 {
+			name = name[:dot]
+		}
+		args = append(args, &arg{name: name, index: len(args)})
+	}
+	// the first wildcard of a * method route is :METHOD
+	offset := len(args) - len(constraints)
+	for i, c := range constraints {
+		if i+offset >= 0 && i+offset < len(args) {
+			args[i+offset].constraint = c
+		}
+	}
+	return args
+}
+
+// treeShape replaces the wildcard names of a tree path, so that routes that
+// only differ by their wildcard names (or constraints) share the same leaf.
+func treeShape(tpath string) string {
+	elements := strings.Split(tpath, "/")
+	for i, el := range elements {
+		if len(el) < 1 || (el[0] != ':' && el[0] != '*') {
+			continue
+		}
+		ext := ""
+		if dot := strings.LastIndex(el, "."); dot != -1 && i == len(elements)-1 {
+			ext = el[dot:]
+		}
+		elements[i] = el[:1] + "_" + ext
+	}
+	return strings.Join(elements, "/")
+}
+
+// matches checks the route constraints against the tree expansions.
+func (r *Route) matches(expansions []string) bool {
+	for _, a := range r.args {
+		if a.constraint != nil && (a.index >= len(expansions) || !a.constraint.MatchString(expansions[a.index])) {
+			return false
+		}
+	}
+	return true
+}
+
+// arg returns the wildcard with the given name.
+func (r *Route) arg(name string) *arg {
+	for _, a := range r.args {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// handler returns the http.Handler of a handler route (nil if it's not
+// registered or if the route runs a controller action).
+func (r *Route) handler() http.Handler {
+	if r.Handler != nil {
+		return r.Handler
+	}
+	if strings.HasPrefix(r.Action, routeHandlerPrefix) && r.app != nil {
+		return r.app.handlerMap[r.Action[len(routeHandlerPrefix):]]
+	}
+	return nil
+}
+
+// hasConstraints checks if any route wildcard has a constraint.
+func (r *Route) hasConstraints() bool {
+	for _, a := range r.args {
+		if a.constraint != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Prepares the route to be used in matching.
 func NewRoute(method, path, action, fixedArgs, routesPath string, line int, tlsonly bool, app *App) (r *Route) {
 	// Handle fixed arguments
@@ -81,6 +257,13 @@ func NewRoute(method, path, action, fixedArgs, routesPath string, line int, tlso
 		app.Logger.Printf("Invalid fixed parameters (%v): for string '%v'\n", err.Error(), fixedArgs)
 	}
 
+	// Handle constraints (e.g. /users/:id<\d+>)
+	rawPath := path
+	path, constraints, err := parseRoutePath(path)
+	if err != nil {
+		app.Logger.Printf("Invalid route path (%v): for string '%v'\n", err.Error(), rawPath)
+	}
+
 	r = &Route{
 		Method:      strings.ToUpper(method),
 		Path:        path,
@@ -91,7 +274,10 @@ func NewRoute(method, path, action, fixedArgs, routesPath string, line int, tlso
 		routesPath:  routesPath,
 		line:        line,
 		app:         app,
+		pathErr:     err,
+		ArgNames:    routeArgNames(fixedArgs),
 	}
+	r.args = treeArgs(r.TreePath, constraints)
 
 	// URL pattern
 	if !strings.HasPrefix(r.Path, "/") {
@@ -115,11 +301,78 @@ func treePath(method, path string) string {
 	return "/" + method + path
 }
 
+// routeHost is a compiled host pattern of a HOST block.
+// Labels starting with a colon capture a route parameter and
+// a * label matches any single label.
+type routeHost struct {
+	pattern  string         // e.g. :tenant.example.com
+	re       *regexp.Regexp // e.g. ^([^.]+)\.example\.com$
+	names    []string       // e.g. tenant
+	wildcard bool
+	tree     *pathtree.Node
+	leafs    []routeLeaf
+}
+
+func newRouteHost(pattern string) (*routeHost, error) {
+	h := &routeHost{
+		pattern: pattern,
+		tree:    pathtree.New(),
+	}
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if len(label) < 1 {
+			return nil, errors.New("host pattern `" + pattern + "` has an empty label")
+		}
+		if label == "*" {
+			labels[i] = "[^.]+"
+			h.wildcard = true
+		} else if label[0] == ':' {
+			if len(label) < 2 {
+				return nil, errors.New("host pattern `" + pattern + "` has an unnamed parameter")
+			}
+			h.names = append(h.names, label[1:])
+			labels[i] = "([^.]+)"
+			h.wildcard = true
+		} else {
+			labels[i] = regexp.QuoteMeta(label)
+		}
+	}
+	re, err := regexp.Compile("(?i)^" + strings.Join(labels, "\\.") + "$")
+	if err != nil {
+		return nil, err
+	}
+	h.re = re
+	return h, nil
+}
+
+// match checks the host (without the port) against the pattern and
+// returns the captured parameters.
+func (h *routeHost) match(host string) (Params, bool) {
+	m := h.re.FindStringSubmatch(host)
+	if m == nil {
+		return nil, false
+	}
+	var params Params
+	if len(h.names) > 0 {
+		params = make(Params)
+		for i, name := range h.names {
+			params[name] = m[i+1]
+		}
+	}
+	return params, true
+}
+
 type Router struct {
-	Routes []*Route
-	Tree   *pathtree.Node
-	path   string // path to the routes file
-	app    *App
+	Routes       []*Route
+	Tree         *pathtree.Node // routes without a host
+	path         string         // path to the routes file
+	app          *App
+	hosts        []*routeHost // routes with a host; exact hosts come first
+	methods      []string     // methods used by the routes (without * and WS)
+	leafs        []routeLeaf  // leafs of Tree, for findRoute
+	mutex        sync.RWMutex // guards the routing table
+	refreshMutex sync.Mutex
+	memRoutes    []*Route // routes added with App.AddRouteLine; kept on Refresh
 }
 
 func (router *Router) Route(req *http.Request) *RouteMatch {
@@ -128,18 +381,24 @@ func (router *Router) Route(req *http.Request) *RouteMatch {
 		req.Method = method
 	}
 
-	leaf, expansions := router.Tree.Find(treePath(req.Method, req.URL.Path))
-	if leaf == nil {
+	router.mutex.RLock()
+	route, expansions, hostParams := router.find(req, req.Method)
+	router.mutex.RUnlock()
+	if route == nil {
 		return nil
 	}
-	route := leaf.Value.(*Route)
 
 	// Create a map of the route parameters.
 	var params Params
-	if len(expansions) > 0 {
+	if len(expansions) > 0 || len(hostParams) > 0 {
 		params = make(Params)
-		for i, v := range expansions {
-			params[leaf.Wildcards[i]] = v
+		for k, v := range hostParams {
+			params[k] = v
+		}
+		for _, a := range route.args {
+			if a.index < len(expansions) {
+				params[a.name] = expansions[a.index]
+			}
 		}
 	}
 
@@ -148,6 +407,17 @@ func (router *Router) Route(req *http.Request) *RouteMatch {
 		return routeMatchNotFound
 	}
 
+	// http.Handler routes
+	if route.Handler != nil || strings.HasPrefix(route.Action, routeHandlerPrefix) {
+		return &RouteMatch{
+			Action:  route.Action,
+			Params:  params,
+			TLSOnly: route.TLSOnly,
+			Filters: route.Filters,
+			Handler: route.handler(),
+		}
+	}
+
 	// If the action is variablized, replace into it with the captured args.
 	controllerName, methodName := route.ControllerName, route.MethodName
 	if controllerName[0] == ':' {
@@ -163,40 +433,250 @@ func (router *Router) Route(req *http.Request) *RouteMatch {
 		Params:         params,
 		FixedParams:    route.FixedParams,
 		TLSOnly:        route.TLSOnly,
+		Filters:        route.Filters,
+		ArgNames:       route.ArgNames,
+	}
+}
+
+// routeCandidates are the routes that share the same tree leaf, in the
+// order they were declared. The first one that satisfies its
+// constraints is used.
+type routeCandidates []*Route
+
+func (c *routeCandidates) match(expansions []string) *Route {
+	for _, route := range *c {
+		if route.matches(expansions) {
+			return route
+		}
+	}
+	return nil
+}
+
+// routeLeaf is a leaf of a routing tree with a tree of its own, so it
+// can be matched when the constraints of a better leaf fail.
+type routeLeaf struct {
+	tree       *pathtree.Node
+	candidates *routeCandidates
+}
+
+// findRoute looks up the tree path and returns the first candidate whose
+// constraints match, along with the wildcard expansions. If the
+// constraints of every candidate fail, the other leafs that match the
+// path are tried in the order they were declared (e.g. /f/*path after
+// /f/:id<int>).
+func findRoute(tree *pathtree.Node, leafs []routeLeaf, tpath string) (*Route, []string) {
+	leaf, expansions := tree.Find(tpath)
+	if leaf == nil {
+		return nil, nil
+	}
+	found := leaf.Value.(*routeCandidates)
+	if route := found.match(expansions); route != nil {
+		return route, expansions
+	}
+	for _, l := range leafs {
+		if l.candidates == found {
+			continue
+		}
+		if leaf, expansions := l.tree.Find(tpath); leaf != nil {
+			if route := l.candidates.match(expansions); route != nil {
+				return route, expansions
+			}
+		}
+	}
+	return nil, nil
+}
+
+// find looks up the request host and path for the given method.
+// Routes of matching hosts are tried before the routes without a host.
+func (router *Router) find(req *http.Request, method string) (route *Route, expansions []string, hostParams Params) {
+	tpath := treePath(method, req.URL.Path)
+	if len(router.hosts) > 0 {
+		host := trimhost(req.Host)
+		for _, h := range router.hosts {
+			var ok bool
+			if hostParams, ok = h.match(host); !ok {
+				continue
+			}
+			if route, expansions = findRoute(h.tree, h.leafs, tpath); route != nil {
+				return
+			}
+		}
+	}
+	route, expansions = findRoute(router.Tree, router.leafs, tpath)
+	hostParams = nil
+	return
+}
+
+// AllowedMethods returns the methods that have a route for the request
+// host and path (e.g. GET, HEAD, OPTIONS, POST), sorted.
+// It returns nil if no route matches the path.
+func (router *Router) AllowedMethods(req *http.Request) []string {
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	allowed := make([]string, 0)
+	for _, method := range router.methods {
+		if route, _, _ := router.find(req, method); route != nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) < 1 {
+		return nil
 	}
+	if StringIndexOf(allowed, http.MethodOptions) == -1 {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	sort.Strings(allowed)
+	return allowed
 }
 
 // Refresh re-reads the routes file and re-calculates the routing table.
-// Returns an error if a specified action could not be found.
+// Returns an error if a specified action could not be found. The current
+// routing table is kept if the routes file has errors. Routes added with
+// App.AddRouteLine come after the routes of the file.
 func (router *Router) Refresh() (err error) {
-	if router.path == "" {
+	router.refreshMutex.Lock()
+	defer router.refreshMutex.Unlock()
+	if router.path == "" || router.path == "memory" {
 		err = router.updateTree()
 		return
 	}
-	router.Routes, err = parseRoutesFile(router.path, "", true, router.app)
+	routes, err := parseRoutesFile(router.path, "", true, router.app)
 	if err != nil {
 		return
 	}
-	err = router.updateTree()
+	err = router.setRoutes(append(routes, router.memRoutes...))
 	return
 }
 
+// addRoutes adds routes that aren't in the routes file (see memRoutes).
+// The routing table is not changed if the routes are invalid.
+func (router *Router) addRoutes(routes ...*Route) error {
+	router.refreshMutex.Lock()
+	defer router.refreshMutex.Unlock()
+	router.mutex.RLock()
+	next := make([]*Route, 0, len(router.Routes)+len(routes))
+	next = append(next, router.Routes...)
+	router.mutex.RUnlock()
+	if err := router.setRoutes(append(next, routes...)); err != nil {
+		return err
+	}
+	router.memRoutes = append(router.memRoutes, routes...)
+	return nil
+}
+
+// updateTree re-calculates the routing table of router.Routes.
 func (router *Router) updateTree() error {
-	router.Tree = pathtree.New()
-	for _, route := range router.Routes {
-		err := router.Tree.Add(route.TreePath, route)
+	return router.setRoutes(router.Routes)
+}
+
+// setRoutes calculates the routing table of routes and swaps it with the
+// current one, so that in-flight requests always see a complete table.
+func (router *Router) setRoutes(routes []*Route) error {
+	tree, leafs, hosts, methods, err := buildRouteTree(routes)
+	if err != nil {
+		return err
+	}
+	router.mutex.Lock()
+	router.Routes = routes
+	router.Tree = tree
+	router.leafs = leafs
+	router.hosts = hosts
+	router.methods = methods
+	router.mutex.Unlock()
+	return nil
+}
+
+func buildRouteTree(routes []*Route) (*pathtree.Node, []routeLeaf, []*routeHost, []string, error) {
+	rtree := pathtree.New()
+	var hosts []*routeHost
+	var methods []string
+	hostMap := make(map[string]*routeHost)
+	leafs := make(map[string]*routeCandidates)
+	hostLeafs := make(map[string][]routeLeaf)
+	// add appends the route to the candidates of its tree leaf;
+	// only routes with constraints can be followed by another candidate.
+	add := func(tree *pathtree.Node, host, tpath string, route *Route) error {
+		key := host + treeShape(tpath)
+		if c, ok := leafs[key]; ok {
+			if last := (*c)[len(*c)-1]; !last.hasConstraints() {
+				return errors.New("duplicate path")
+			}
+			*c = append(*c, route)
+			return nil
+		}
+		c := &routeCandidates{route}
+		leafs[key] = c
+		ltree := pathtree.New()
+		if err := ltree.Add(tpath, c); err != nil {
+			return err
+		}
+		hostLeafs[host] = append(hostLeafs[host], routeLeaf{ltree, c})
+		return tree.Add(tpath, c)
+	}
+	for _, route := range routes {
+		if route.pathErr != nil {
+			return nil, nil, nil, nil, routeError(route.pathErr, route.routesPath, "", route.line)
+		}
+		tree := rtree
+		if len(route.Host) > 0 {
+			h, ok := hostMap[route.Host]
+			if !ok {
+				var err error
+				if h, err = newRouteHost(route.Host); err != nil {
+					return nil, nil, nil, nil, routeError(err, route.routesPath, "", route.line)
+				}
+				hostMap[route.Host] = h
+				hosts = append(hosts, h)
+			}
+			tree = h.tree
+		}
+
+		err := add(tree, route.Host, route.TreePath, route)
 
 		// Allow GETs to respond to HEAD requests.
 		if err == nil && route.Method == "GET" {
-			err = router.Tree.Add(treePath("HEAD", route.Path), route)
+			err = add(tree, route.Host, treePath("HEAD", route.Path), route)
+			if StringIndexOf(methods, "HEAD") == -1 {
+				methods = append(methods, "HEAD")
+			}
+		}
+		if route.Method != "*" && route.Method != "WS" && StringIndexOf(methods, route.Method) == -1 {
+			methods = append(methods, route.Method)
 		}
 
 		// Error adding a route to the pathtree.
 		if err != nil {
-			return routeError(err, route.routesPath, "", route.line)
+			return nil, nil, nil, nil, routeError(err, route.routesPath, "", route.line)
 		}
 	}
-	return nil
+	// exact hosts are matched before wildcard hosts
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return !hosts[i].wildcard && hosts[j].wildcard
+	})
+	for _, h := range hosts {
+		h.leafs = hostLeafs[h.pattern]
+	}
+	return rtree, hostLeafs[""], hosts, methods, nil
+}
+
+// routeFiles lists the routes file and every included file that
+// declares routes.
+func (router *Router) routeFiles() []string {
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	files := make([]string, 0)
+	if router.path != "" && router.path != "memory" {
+		files = append(files, filepath.Clean(router.path))
+	}
+	for _, route := range router.Routes {
+		if route.routesPath == "memory" {
+			continue
+		}
+		if p := filepath.Clean(route.routesPath); StringIndexOf(files, p) == -1 {
+			files = append(files, p)
+		}
+	}
+	return files
 }
 
 // parseRoutesFile reads the given routes file and returns the contained routes.
@@ -210,30 +690,135 @@ func parseRoutesFile(routesPath, joinedPath string, validate bool, app *App) ([]
 
 // parseRoutes reads the content of a routes file into the routing table.
 func parseRoutes(routesPath, joinedPath, content string, validate bool, app *App) ([]*Route, error) {
+	return parseRoutesIncluded(routesPath, content, routeBlock{prefix: joinedPath, line: -1}, validate, app, nil)
+}
+
+// routeBlock is an open GROUP or HOST block of a routes file.
+type routeBlock struct {
+	prefix string // e.g. /admin
+	host   string // e.g. :tenant.example.com
+	line   int    // line of the opening directive
+}
+
+// parseRoutesIncluded does the work of parseRoutes. parent is the block this
+// file was included from and includeStack holds the routes files that are
+// currently being parsed (the parents of routesPath), to avoid include cycles.
+func parseRoutesIncluded(routesPath, content string, parent routeBlock, validate bool, app *App, includeStack []string) ([]*Route, error) {
 	var routes []*Route
 
+	includeStack = append(includeStack, filepath.Clean(routesPath))
+	// blocks holds every open GROUP/HOST block;
+	// blocks[0] is the block this file was included from
+	blocks := []routeBlock{parent}
+
 	for n, line := range strings.Split(content, "\n") {
 		line = strings.TrimSpace(line)
 		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
+		block := blocks[len(blocks)-1]
+
+		// GROUP /prefix {
+		if isRouteDirective(line, "GROUP") {
+			gpath, err := routeParseBlockLine(line, "GROUP")
+			if err != nil {
+				return nil, routeError(err, routesPath, content, n)
+			}
+			if gpath[0] != '/' {
+				return nil, routeError(errors.New("GROUP path must begin with a forward slash"), routesPath, content, n)
+			}
+			blocks = append(blocks, routeBlock{joinRoutePath(block.prefix, gpath), block.host, n})
+			continue
+		}
+
+		// HOST api.example.com {
+		if isRouteDirective(line, "HOST") {
+			host, err := routeParseBlockLine(line, "HOST")
+			if err != nil {
+				return nil, routeError(err, routesPath, content, n)
+			}
+			if len(block.host) > 0 {
+				return nil, routeError(errors.New("HOST blocks cannot be nested"), routesPath, content, n)
+			}
+			if _, err := newRouteHost(host); err != nil {
+				return nil, routeError(err, routesPath, content, n)
+			}
+			blocks = append(blocks, routeBlock{block.prefix, host, n})
+			continue
+		}
+
+		// RESOURCE /posts Posts (or RESOURCE /users Users { for nested resources)
+		if isRouteDirective(line, "RESOURCE") {
+			rpath, controller, nested, err := routeParseResourceLine(line)
+			if err != nil {
+				return nil, routeError(err, routesPath, content, n)
+			}
+			rpath = joinRoutePath(block.prefix, rpath)
+			for _, route := range resourceRoutes(rpath, controller, routesPath, n, app) {
+				route.Host = block.host
+				if validate {
+					if err := validateRoute(route); err != nil {
+						return nil, routeError(err, routesPath, content, n)
+					}
+				}
+				routes = append(routes, route)
+			}
+			if nested {
+				blocks = append(blocks, routeBlock{rpath + "/:" + resourceParamName(rpath), block.host, n})
+			}
+			continue
+		}
+
+		// end of a GROUP/HOST/RESOURCE block
+		if line[0] == '}' {
+			if rest := strings.TrimSpace(line[1:]); len(rest) > 0 && rest[0] != '#' {
+				return nil, routeError(errors.New("unexpected `"+rest+"` after `}`"), routesPath, content, n)
+			}
+			if len(blocks) < 2 {
+				return nil, routeError(errors.New("`}` without a matching GROUP, HOST or RESOURCE"), routesPath, content, n)
+			}
+			blocks = blocks[:len(blocks)-1]
+			continue
+		}
+
+		// include path/to/other.cfg
+		if isRouteDirective(line, "include") {
+			incPath := routeParseIncludeLine(line)
+			if len(incPath) < 1 {
+				return nil, routeError(errors.New("include without a file path"), routesPath, content, n)
+			}
+			if !filepath.IsAbs(incPath) {
+				incPath = filepath.Join(filepath.Dir(routesPath), incPath)
+			}
+			incPath = filepath.Clean(incPath)
+			if StringIndexOf(includeStack, incPath) != -1 {
+				return nil, routeError(errors.New("include cycle detected: "+incPath), routesPath, content, n)
+			}
+			contentBytes, err := ioutil.ReadFile(incPath)
+			if err != nil {
+				return nil, routeError(errors.New("Failed to load included routes file: "+err.Error()), routesPath, content, n)
+			}
+			incRoutes, err := parseRoutesIncluded(incPath, string(contentBytes), block, validate, app, includeStack)
+			if err != nil {
+				// the error already points to the included file
+				return nil, err
+			}
+			routes = append(routes, incRoutes...)
+			continue
+		}
 
 		// A single route
-		method, path, action, fixedArgs, tls, found, errmsg, errbyte := routeParseLine(line)
+		method, path, action, fixedArgs, filters, tls, found, errmsg, errbyte := routeParseLine(line)
 		if !found {
-			app.Logger.Printf("ROUTER ERROR on line %d:\n%s <<[%d] %s\n", n, line[:errbyte], errbyte, errmsg)
+			app.Logger.Printf("ROUTER ERROR on %s line %d:\n%s <<[%d] %s\n", routesPath, n+1, line[:errbyte], errbyte, errmsg)
 			continue
 		}
 
-		// this will avoid accidental double forward slashes in a route.
-		// this also avoids pathtree freaking out and causing a runtime panic
-		// because of the double slashes
-		if strings.HasSuffix(joinedPath, "/") && strings.HasPrefix(path, "/") {
-			joinedPath = joinedPath[0 : len(joinedPath)-1]
-		}
-		path = strings.Join([]string{joinedPath, path}, "")
+		path = joinRoutePath(block.prefix, path)
 
 		route := NewRoute(method, path, action, fixedArgs, routesPath, n, tls, app)
+		route.Filters = filters
+		route.Host = block.host
 		routes = append(routes, route)
 
 		if validate {
@@ -243,9 +828,146 @@ func parseRoutes(routesPath, joinedPath, content string, validate bool, app *App
 		}
 	}
 
+	if len(blocks) > 1 {
+		return nil, routeError(errors.New("block is not closed"), routesPath, content, blocks[len(blocks)-1].line)
+	}
+
 	return routes, nil
 }
 
+// joinRoutePath prepends a group prefix to a route path.
+func joinRoutePath(prefix, path string) string {
+	// this will avoid accidental double forward slashes in a route.
+	// this also avoids pathtree freaking out and causing a runtime panic
+	// because of the double slashes
+	if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, "/") {
+		prefix = prefix[0 : len(prefix)-1]
+	}
+	return strings.Join([]string{prefix, path}, "")
+}
+
+// isRouteDirective checks if the line starts with the directive keyword
+// (e.g. GROUP or include) followed by a space.
+func isRouteDirective(line, directive string) bool {
+	if len(line) <= len(directive) || !strings.EqualFold(line[:len(directive)], directive) {
+		return false
+	}
+	return line[len(directive)] == ' ' || line[len(directive)] == '\t'
+}
+
+// routeParseBlockLine parses a line like `GROUP /admin {` and returns
+// the directive argument.
+func routeParseBlockLine(line, directive string) (string, error) {
+	line = stripRouteComment(line[len(directive):])
+	if !strings.HasSuffix(line, "{") {
+		return "", errors.New(directive + " must end with `{`")
+	}
+	arg := strings.TrimSpace(line[:len(line)-1])
+	if len(arg) < 1 {
+		return "", errors.New(directive + " without an argument")
+	}
+	if strings.ContainsAny(arg, " \t") {
+		return "", errors.New(directive + " argument `" + arg + "` is invalid")
+	}
+	return arg, nil
+}
+
+// resourceActions are the routes a RESOURCE directive expands to.
+var resourceActions = []struct {
+	method, suffix, name string
+}{
+	{"GET", "", "Index"},
+	{"GET", "/new", "New"},
+	{"POST", "", "Create"},
+	{"GET", "/:id", "Show"},
+	{"GET", "/:id/edit", "Edit"},
+	{"PUT", "/:id", "Update"},
+	{"PATCH", "/:id", "Update"},
+	{"DELETE", "/:id", "Destroy"},
+}
+
+// routeParseResourceLine parses a line like `RESOURCE /posts Posts` or
+// `RESOURCE /users Users {` (nested is true).
+func routeParseResourceLine(line string) (path, controller string, nested bool, err error) {
+	line = stripRouteComment(line[len("RESOURCE"):])
+	if strings.HasSuffix(line, "{") {
+		nested = true
+		line = line[:len(line)-1]
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return "", "", false, errors.New("RESOURCE expects a path and a controller (e.g. RESOURCE /posts Posts)")
+	}
+	path, controller = fields[0], fields[1]
+	if path[0] != '/' {
+		return "", "", false, errors.New("RESOURCE path must begin with a forward slash")
+	}
+	if strings.ContainsAny(controller, ".:") {
+		return "", "", false, errors.New("RESOURCE controller `" + controller + "` is invalid")
+	}
+	return strings.TrimSuffix(path, "/"), controller, nested, nil
+}
+
+// resourceRoutes expands a RESOURCE directive. If the controller is
+// registered, the actions it doesn't have are skipped with a warning.
+func resourceRoutes(path, controller, routesPath string, line int, app *App) []*Route {
+	c := app.controllerMap[controller]
+	routes := make([]*Route, 0, len(resourceActions))
+	for _, ra := range resourceActions {
+		route := NewRoute(ra.method, path+ra.suffix, controller+"."+ra.name, "", routesPath, line, false, app)
+		// typed action args are bound to the last wildcards
+		// (e.g. Show(in, id) or Show(in, userID, id) for /users/:user_id/posts/:id)
+		names := make([]string, 0, len(route.args))
+		for _, a := range route.args {
+			names = append(names, a.name)
+		}
+		if c != nil {
+			m, ok := c.getMethod(ra.name)
+			if !ok {
+				app.Logvln("RESOURCE", path, "skipped", ra.method, controller+"."+ra.name, "(method not found)")
+				continue
+			}
+			if len(m.Args) < len(names) {
+				names = names[len(names)-len(m.Args):]
+			}
+		}
+		route.ArgNames = names
+		routes = append(routes, route)
+	}
+	if c != nil && len(routes) < len(resourceActions) {
+		app.Logger.Printf("RESOURCE %s: %s doesn't implement every action (%d of %d routes)\n", path, controller, len(routes), len(resourceActions))
+	}
+	return routes
+}
+
+// resourceParamName is the id param of a parent resource
+// (e.g. /users => user_id).
+func resourceParamName(path string) string {
+	name := path[strings.LastIndex(path, "/")+1:]
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		name = name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		name = name[:len(name)-1]
+	}
+	return name + "_id"
+}
+
+// routeParseIncludeLine parses a line like `include path/to/other.cfg`
+// and returns the (unresolved) file path.
+func routeParseIncludeLine(line string) string {
+	line = stripRouteComment(line[len("include"):])
+	return strings.Trim(line, "\"'")
+}
+
+// stripRouteComment removes a trailing # comment and surrounding spaces.
+func stripRouteComment(line string) string {
+	if i := strings.Index(line, "#"); i != -1 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
 // validateRoute checks that every specified action exists.
 func validateRoute(route *Route) error {
 	// Skip 404s
@@ -253,6 +975,21 @@ func validateRoute(route *Route) error {
 		return nil
 	}
 
+	// Every filter must be registered.
+	for _, name := range route.Filters {
+		if _, ok := route.app.filterMap[name]; !ok {
+			return errors.New("Filter " + name + " not found!")
+		}
+	}
+
+	// Handler routes need a registered handler.
+	if strings.HasPrefix(route.Action, routeHandlerPrefix) {
+		if route.handler() == nil {
+			return errors.New("Handler " + route.Action[len(routeHandlerPrefix):] + " not found!")
+		}
+		return nil
+	}
+
 	// We should be able to load the action.
 	parts := strings.Split(route.Action, ".")
 	if len(parts) != 2 {
@@ -270,17 +1007,25 @@ func validateRoute(route *Route) error {
 		return errors.New("Controller " + parts[0] + " not found!")
 	}
 
-	if _, ok := c.getMethod(parts[1]); !ok {
+	m, ok := c.getMethod(parts[1])
+	if !ok {
 		return errors.New("Controller " + parts[0] + " has no method " + parts[1])
 	}
 
+	// Typed action args need a value (or a name to bind) each.
+	if len(m.Args) > 0 && len(m.Args) != len(route.ArgNames) {
+		return fmt.Errorf("Controller %s method %s takes %d args, but the route declares %d",
+			parts[0], parts[1], len(m.Args), len(route.ArgNames))
+	}
+
 	return nil
 }
 
-func routeParseLine(line string) (method, path, action, fixedArgs string, tls, found bool, errormessage string, errorbyte int) {
+func routeParseLine(line string) (method, path, action, fixedArgs string, filters []string, tls, found bool, errormessage string, errorbyte int) {
 	stage := 0
 	begin := false
 	quoted := false
+	bare := false // inside an unquoted :name arg
 	bbackslashes := 0
 	buf := new(bytes.Buffer)
 	for i, r := range line {
@@ -348,10 +1093,13 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 					buf.WriteRune(r)
 				}
 			} else {
-				if r == ' ' || r == '\t' || r == '(' {
+				if r == ' ' || r == '\t' || r == '(' || r == '[' {
 					if r == '(' {
 						// jump to fixedArgs
 						stage = 3
+					} else if r == '[' {
+						// jump to filters
+						stage = 5
 					} else {
 						stage = 4
 						// jump to TLS checker
@@ -373,12 +1121,19 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 				}
 				if r == ' ' || r == '\t' {
 					continue
+				} else if r == ')' {
+					// no args at all
+					found = true
+					stage = 4
 				} else {
 					begin = true
 					buf.WriteRune(r)
 					if r == '"' {
 						quoted = true
 					}
+					if r == ':' {
+						bare = true
+					}
 				}
 			} else {
 				if quoted {
@@ -396,6 +1151,18 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 					}
 					buf.WriteRune(r)
 				} else {
+					if bare {
+						if isRouteArgNameRune(r) {
+							buf.WriteRune(r)
+							continue
+						}
+						bare = false
+					}
+					if r == ':' {
+						bare = true
+						buf.WriteRune(r)
+						continue
+					}
 					if r == ')' {
 						begin = false
 						quoted = false
@@ -430,6 +1197,14 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 				}
 				if r == ' ' || r == '\t' {
 					continue
+				} else if r == '[' {
+					if filters != nil {
+						errormessage = "filters declared twice"
+						found = false
+						return
+					}
+					// jump to filters
+					stage = 5
 				} else {
 					begin = true
 					buf.WriteRune(r)
@@ -443,10 +1218,13 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 					return
 				} else if r == ' ' || r == '\t' {
 					bs := buf.String()
-					if bs == "TLS" {
+					if bs == "TLS" && !tls {
 						tls = true
 						found = true
-						return
+						// filters may still follow
+						begin = false
+						buf.Reset()
+						continue
 					} else {
 						// at this moment, there is no other valid
 						// parameter besides TLS
@@ -458,6 +1236,21 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 					buf.WriteRune(r)
 				}
 			}
+		case 5:
+			// [filter1, filter2] (this is an optional parameter)
+			if r == ']' {
+				filters = routeParseFilters(buf.String())
+				buf.Reset()
+				found = true
+				stage = 4 // go back to the TLS check
+				continue
+			}
+			if r == '[' || r == '#' || r == '(' || r == ')' {
+				errormessage = fmt.Sprintf("bad character (%v) between filters", string(r))
+				found = false
+				return
+			}
+			buf.WriteRune(r)
 		}
 	}
 	if stage < 2 {
@@ -485,16 +1278,84 @@ func routeParseLine(line string) (method, path, action, fixedArgs string, tls, f
 			found = true
 			return
 		}
-		if buf.String() == "TLS" {
+		if buf.String() == "TLS" && !tls {
 			buf.Reset()
 			tls = true
 			found = true
 			return
 		}
+		errormessage = "TLS parameter `" + buf.String() + "` invalid"
+		found = false
+		return
+	}
+	if stage == 5 {
+		// EOL while still in stage 5
+		errormessage = "filters bracket not closed"
+		found = false
+		return
 	}
 	return
 }
 
+// isRouteArgNameRune checks if r can be part of a :name action arg.
+func isRouteArgNameRune(r rune) bool {
+	return r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// routeArgNames returns the request value name bound to every action
+// arg of fixedArgs (e.g. `"okay",:id` returns "", "id"). Quoted args are
+// fixed values and have no name.
+func routeArgNames(fixedArgs string) []string {
+	names := make([]string, 0)
+	if len(fixedArgs) < 1 {
+		return names
+	}
+	quoted := false
+	bbackslashes := 0
+	field := new(bytes.Buffer)
+	flush := func() {
+		f := strings.TrimSpace(field.String())
+		if strings.HasPrefix(f, ":") {
+			names = append(names, f[1:])
+		} else {
+			names = append(names, "")
+		}
+		field.Reset()
+	}
+	for _, r := range fixedArgs {
+		if quoted {
+			if r == '\\' {
+				bbackslashes++
+			} else {
+				if r == '"' && bbackslashes%2 == 0 {
+					quoted = false
+				}
+				bbackslashes = 0
+			}
+		} else if r == '"' {
+			quoted = true
+		} else if r == ',' {
+			flush()
+			continue
+		}
+		field.WriteRune(r)
+	}
+	flush()
+	return names
+}
+
+// routeParseFilters splits a filters list like "auth, csrf".
+func routeParseFilters(list string) []string {
+	filters := make([]string, 0)
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			filters = append(filters, v)
+		}
+	}
+	return filters
+}
+
 // routeError adds context to a simple error message.
 func routeError(err error, routesPath, content string, n int) error {
 	return errors.New("Route validation error; " + err.Error() + "; " + routesPath + "; line " + fmt.Sprintf("%v", n+1))
@@ -518,6 +1379,71 @@ func (a *ActionDefinition) String() string {
 	return a.Url
 }
 
+// reverseCandidate picks the route of an action (e.g. a nested resource)
+// whose path args are all in argValues and that uses most of them.
+// It returns nil if no route has all its args.
+func (router *Router) reverseCandidate(controllerName, methodName string, argValues map[string]string) *Route {
+	var best *Route
+	bestn := -1
+	for _, route := range router.Routes {
+		if !route.reverses(controllerName, methodName) {
+			continue
+		}
+		n := 0
+		for _, a := range route.args {
+			// filled by the action or the method
+			if ":"+a.name == route.ControllerName || ":"+a.name == route.MethodName || a.name == "METHOD" {
+				continue
+			}
+			if _, ok := argValues[a.name]; !ok {
+				n = -1
+				break
+			}
+			n++
+		}
+		if n > bestn {
+			best, bestn = route, n
+		}
+	}
+	return best
+}
+
+// reverses checks if the route can be used to reach the action.
+func (r *Route) reverses(controllerName, methodName string) bool {
+	// Skip routes without either a ControllerName or MethodName
+	if r.ControllerName == "" || r.MethodName == "" {
+		return false
+	}
+
+	// Check that the action matches or is a wildcard.
+	controllerWildcard := r.ControllerName[0] == ':'
+	methodWildcard := r.MethodName[0] == ':'
+	if (!controllerWildcard && r.ControllerName != controllerName) ||
+		(!methodWildcard && r.MethodName != methodName) {
+		return false
+	}
+	return true
+}
+
+// HasAction checks if any route leads to the action (e.g. "Posts.Show").
+func (router *Router) HasAction(action string) bool {
+	actionSplit := strings.Split(action, ".")
+	if len(actionSplit) != 2 {
+		return false
+	}
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	for _, route := range router.Routes {
+		if route.reverses(actionSplit[0], actionSplit[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reverse builds the URL of the action (e.g. "Posts.Show"). Route args are
+// taken from argValues and the remaining values go to the query string.
+// argValues is not modified.
 func (router *Router) Reverse(action string, argValues map[string]string) *ActionDefinition {
 	actionSplit := strings.Split(action, ".")
 	if len(actionSplit) != 2 {
@@ -526,19 +1452,22 @@ func (router *Router) Reverse(action string, argValues map[string]string) *Actio
 	}
 	controllerName, methodName := actionSplit[0], actionSplit[1]
 
+	// work on a copy, so the caller's map is left untouched
+	args := make(map[string]string, len(argValues))
+	for k, v := range argValues {
+		args[k] = v
+	}
+	argValues = args
+
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	best := router.reverseCandidate(controllerName, methodName, argValues)
 	for _, route := range router.Routes {
-		// Skip routes without either a ControllerName or MethodName
-		if route.ControllerName == "" || route.MethodName == "" {
+		if !route.reverses(controllerName, methodName) || (best != nil && route != best) {
 			continue
 		}
-
-		// Check that the action matches or is a wildcard.
 		controllerWildcard := route.ControllerName[0] == ':'
 		methodWildcard := route.MethodName[0] == ':'
-		if (!controllerWildcard && route.ControllerName != controllerName) ||
-			(!methodWildcard && route.MethodName != methodName) {
-			continue
-		}
 		if controllerWildcard {
 			argValues[route.ControllerName[1:]] = controllerName
 		}
@@ -552,20 +1481,58 @@ func (router *Router) Reverse(action string, argValues map[string]string) *Actio
 			pathElements = strings.Split(route.Path, "/")
 		)
 		for i, el := range pathElements {
-			if el == "" || el[0] != ':' {
+			if el == "" || (el[0] != ':' && el[0] != '*') {
 				continue
 			}
 
-			val, ok := argValues[el[1:]]
+			// keep the extension of the last element (e.g. /posts/:id.json)
+			name, ext := el[1:], ""
+			if dot := strings.LastIndex(name, "."); dot != -1 && i == len(pathElements)-1 {
+				name, ext = name[:dot], name[dot:]
+			}
+			val, ok := argValues[name]
 			if !ok {
 				val = "<nil>"
-				router.app.Logger.Println("router: reverse route missing route arg ", el[1:])
+				router.app.Logger.Println("router: reverse route missing route arg ", name)
+			} else if a := route.arg(name); a != nil && a.constraint != nil && !a.constraint.MatchString(val) {
+				router.app.Logger.Println("router: reverse route arg ", name, " does not match constraint ", a.constraint.String())
+			}
+			if el[0] == ':' {
+				val = url.PathEscape(val)
 			}
-			pathElements[i] = val
-			delete(argValues, el[1:])
+			pathElements[i] = val + ext
+			delete(argValues, name)
 			continue
 		}
 
+		// Build up the host. Hosts with * labels can't be built, so
+		// Host is left empty.
+		host := router.app.Config.DomainName
+		if len(route.Host) > 0 {
+			labels := strings.Split(route.Host, ".")
+			wildcard := false
+			for i, label := range labels {
+				if label == "*" {
+					wildcard = true
+					continue
+				}
+				if len(label) < 2 || label[0] != ':' {
+					continue
+				}
+				val, ok := argValues[label[1:]]
+				if !ok {
+					val = "<nil>"
+					router.app.Logger.Println("router: reverse route missing host arg ", label[1:])
+				}
+				labels[i] = val
+				delete(argValues, label[1:])
+			}
+			host = strings.Join(labels, ".")
+			if wildcard {
+				host = ""
+			}
+		}
+
 		// Add any args that were not inserted into the path into the query string.
 		for k, v := range argValues {
 			queryValues.Set(k, v)
@@ -590,7 +1557,7 @@ func (router *Router) Reverse(action string, argValues map[string]string) *Actio
 			Star:   star,
 			Action: action,
 			Args:   argValues,
-			Host:   "TODO",
+			Host:   host,
 		}
 	}
 	router.app.Logger.Println("Failed to find reverse route:", action, argValues)
@@ -1,8 +1,16 @@
 package goboots
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 )
 
 func TestRouteLineReader(t *testing.T) {
@@ -74,7 +82,7 @@ func TestRouteLineReader(t *testing.T) {
 			"", "", "", "", ""},
 	}
 	for n, v := range paths {
-		method, path, action, fixedArgs, tls, found, errormessage, _ := routeParseLine(v[0])
+		method, path, action, fixedArgs, _, tls, found, errormessage, _ := routeParseLine(v[0])
 		if found && v[1] != "true" {
 			t.Fatalf("Route \n%v\n  Should be invalid!\n", v[0])
 		} else if !found && v[1] == "true" {
@@ -141,3 +149,636 @@ func BenchmarkLineReaderNew(b *testing.B) {
 		}
 	}
 }
+
+func TestRouteGroupsAndIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboots_routes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "routes"), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "Routes.cfg"), []byte(`GET / App.Index
+GROUP /admin {
+	GET /users App.Users # list users
+	GROUP /api/ {
+		include routes/api.cfg
+	}
+}
+GET /about App.About
+`), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "routes", "api.cfg"), []byte(`GET /status App.Status
+POST /users/:id App.Users
+`), 0777)
+
+	app := NewApp()
+	routes, err := parseRoutesFile(filepath.Join(dir, "Routes.cfg"), "", false, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/", "/admin/users", "/admin/api/status", "/admin/api/users/:id", "/about"}
+	if len(routes) != len(expected) {
+		t.Fatalf("expected %v routes, got %v", len(expected), len(routes))
+	}
+	for i, v := range expected {
+		if routes[i].Path != v {
+			t.Fatalf("route %v path should be '%v' but it is '%v'", i, v, routes[i].Path)
+		}
+	}
+	if routes[2].routesPath != filepath.Join(dir, "routes", "api.cfg") || routes[2].line != 0 {
+		t.Fatalf("route 2 should come from api.cfg line 1 (got %v line %v)", routes[2].routesPath, routes[2].line+1)
+	}
+
+	// errors inside included files must point to the included file
+	ioutil.WriteFile(filepath.Join(dir, "routes", "api.cfg"), []byte(`GET /status App.Status
+}
+`), 0777)
+	_, err = parseRoutesFile(filepath.Join(dir, "Routes.cfg"), "", false, app)
+	if err == nil || !strings.Contains(err.Error(), "api.cfg; line 2") {
+		t.Fatalf("expected an error on api.cfg line 2, got %v", err)
+	}
+
+	// include cycles
+	ioutil.WriteFile(filepath.Join(dir, "routes", "api.cfg"), []byte(`include ../Routes.cfg`), 0777)
+	_, err = parseRoutesFile(filepath.Join(dir, "Routes.cfg"), "", false, app)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected an include cycle error, got %v", err)
+	}
+
+	// unclosed groups
+	_, err = parseRoutes("memory", "", "GROUP /admin {\nGET /users App.Users\n", false, app)
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected an unclosed GROUP error on line 1, got %v", err)
+	}
+}
+
+func TestRouteFilters(t *testing.T) {
+	lines := [][]string{
+		{"GET /admin/users Admin.Users [auth,csrf]", "auth,csrf", ""},
+		{"GET /admin/users Admin.Users [ auth, csrf ] # comment", "auth,csrf", ""},
+		{"GET /admin/users Admin.Users[auth]", "auth", ""},
+		{"GET /admin/users Admin.Users TLS [auth]", "auth", "TLS"},
+		{"GET /admin/users Admin.Users [auth] TLS", "auth", "TLS"},
+		{`POST /action/:id Home.Action("a", "b") [csrf] TLS`, "csrf", "TLS"},
+	}
+	for _, v := range lines {
+		_, _, _, _, filters, tls, found, errormessage, _ := routeParseLine(v[0])
+		if !found {
+			t.Fatalf("Route \n%v\n (%s)  Should be valid!\n", v[0], errormessage)
+		}
+		if strings.Join(filters, ",") != v[1] {
+			t.Fatalf("Filters of route '%v' should be '%v' but they are '%v'", v[0], v[1], filters)
+		}
+		if tls != (v[2] == "TLS") {
+			t.Fatalf("TLS of route '%v' should be %v", v[0], v[2] == "TLS")
+		}
+	}
+	invalid := []string{
+		"GET /admin/users Admin.Users [auth",
+		"GET /admin/users Admin.Users [auth] [csrf]",
+		"GET /admin/users Admin.Users [auth # comment]",
+	}
+	for _, v := range invalid {
+		if _, _, _, _, _, _, found, _, _ := routeParseLine(v); found {
+			t.Fatalf("Route \n%v\n  Should be invalid!\n", v)
+		}
+	}
+}
+
+type filterTestController struct {
+	Controller
+}
+
+func (c *filterTestController) Users(in *In) *Out {
+	return in.OutputString("users")
+}
+
+func (c *filterTestController) Public(in *In) *Out {
+	return in.OutputString("public")
+}
+
+func TestRouteFiltersRun(t *testing.T) {
+	app := NewApp()
+	app.RegisterController(&filterTestController{})
+	app.RegisterFilter("auth", func(in *In) bool {
+		if in.R.Header.Get("Authorization") == "" {
+			in.W.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	})
+	app.AddRouteLine("GET /admin/users filterTestController.Users [auth]")
+	app.AddRouteLine("GET /public filterTestController.Public")
+
+	w, r := testRequest("GET", "/admin/users")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %v", w.Code)
+	}
+	w, r = testRequest("GET", "/admin/users")
+	r.Header.Set("Authorization", "yes")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "users" {
+		t.Fatalf("expected 200 users, got %v %v", w.Code, w.Body.String())
+	}
+	w, r = testRequest("GET", "/public")
+	app.ServeHTTP(w, r)
+	if w.Body.String() != "public" {
+		t.Fatalf("expected public, got %v", w.Body.String())
+	}
+
+	route := NewRoute("GET", "/x", "filterTestController.Users", "", "memory", 0, false, app)
+	route.Filters = []string{"nope"}
+	if err := validateRoute(route); err == nil {
+		t.Fatal("validateRoute should fail on unregistered filters")
+	}
+}
+
+func TestRouteHosts(t *testing.T) {
+	app := NewApp()
+	app.Config.DomainName = "www.example.com"
+	routes, err := parseRoutes("memory", "", `GET / App.Index
+HOST api.example.com {
+	GET / Api.Index
+	GROUP /v1 {
+		GET /users/:id Api.User
+	}
+}
+HOST :tenant.example.com {
+	GET / Tenant.Index
+}
+HOST *.cdn.example.com {
+	GET /assets Cdn.Assets
+}
+`, false, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Router = NewRouter(app, "")
+	app.Router.Routes = routes
+	if err := app.Router.updateTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][]string{
+		{"api.example.com", "/", "Api.Index", ""},
+		{"API.example.com:8080", "/v1/users/5", "Api.User", ""},
+		{"acme.example.com", "/", "Tenant.Index", "acme"},
+		{"example.org", "/", "App.Index", ""},
+		{"a.b.example.com", "/", "App.Index", ""},
+	}
+	for _, v := range cases {
+		_, r := testRequest("GET", "http://"+v[0]+v[1])
+		match := app.Router.Route(r)
+		if match == nil {
+			t.Fatalf("%v%v should match %v", v[0], v[1], v[2])
+		}
+		if match.ControllerName+"."+match.MethodName != v[2] {
+			t.Fatalf("%v%v should match %v but matched %v.%v", v[0], v[1], v[2], match.ControllerName, match.MethodName)
+		}
+		if match.Params["tenant"] != v[3] {
+			t.Fatalf("%v%v tenant param should be '%v' but it is '%v'", v[0], v[1], v[3], match.Params["tenant"])
+		}
+	}
+
+	if def := app.Router.Reverse("Tenant.Index", map[string]string{"tenant": "acme"}); def == nil || def.Host != "acme.example.com" {
+		t.Fatalf("reverse host should be acme.example.com, got %v", def)
+	}
+	if def := app.Router.Reverse("App.Index", map[string]string{}); def == nil || def.Host != "www.example.com" {
+		t.Fatalf("reverse host should be www.example.com, got %v", def)
+	}
+	if def := app.Router.Reverse("Cdn.Assets", map[string]string{}); def == nil || def.Host != "" || def.Url != "/assets" {
+		t.Fatalf("reverse host of a * host should be empty, got %v", def)
+	}
+
+	if _, err := parseRoutes("memory", "", "HOST a.com {\nHOST b.com {\n}\n}\n", false, app); err == nil {
+		t.Fatal("nested HOST blocks should fail")
+	}
+}
+
+func TestRouteConstraints(t *testing.T) {
+	app := NewApp()
+	routes, err := parseRoutes("memory", "", `GET /users/:id<\d+> Users.Show
+GET /users/:name Users.ByName
+GET /posts/:slug<[a-z-]+> Posts.Show
+GET /posts/:id<int>.json Posts.JSON
+GROUP /orgs/:org<alpha> {
+	GET /members Orgs.Members
+}
+GET /f/:id<int> Files.ByID
+GET /f/*path Files.ByPath
+GET /docs/*page<[a-z/]+> Docs.Show
+GET /n/:v<(?P<word>[a-z]+)> Names.Show
+`, false, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes[0].Path != "/users/:id" {
+		t.Fatalf("constraints should be removed from the path, got %v", routes[0].Path)
+	}
+	app.Router = NewRouter(app, "")
+	app.Router.Routes = routes
+	if err := app.Router.updateTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][]string{
+		{"/users/42", "Users.Show", "id", "42"},
+		{"/users/bob", "Users.ByName", "name", "bob"},
+		{"/posts/hello-world", "Posts.Show", "slug", "hello-world"},
+		{"/posts/Hello_World", "", "", ""},
+		{"/posts/-12.json", "Posts.JSON", "id", "-12"},
+		{"/posts/abc.json", "", "", ""},
+		{"/orgs/acme/members", "Orgs.Members", "org", "acme"},
+		{"/orgs/acme1/members", "", "", ""},
+		{"/f/12", "Files.ByID", "id", "12"},
+		{"/f/abc", "Files.ByPath", "path", "abc"},
+		{"/f/a/b", "Files.ByPath", "path", "a/b"},
+		{"/docs/guide/routes", "Docs.Show", "page", "guide/routes"},
+		{"/docs/v2", "", "", ""},
+		{"/n/bob", "Names.Show", "v", "bob"},
+	}
+	for _, v := range cases {
+		_, r := testRequest("GET", v[0])
+		match := app.Router.Route(r)
+		if v[1] == "" {
+			if match != nil {
+				t.Fatalf("%v should not match, but matched %v.%v", v[0], match.ControllerName, match.MethodName)
+			}
+			continue
+		}
+		if match == nil {
+			t.Fatalf("%v should match %v", v[0], v[1])
+		}
+		if match.ControllerName+"."+match.MethodName != v[1] {
+			t.Fatalf("%v should match %v but matched %v.%v", v[0], v[1], match.ControllerName, match.MethodName)
+		}
+		if match.Params[v[2]] != v[3] {
+			t.Fatalf("%v param %v should be '%v' but it is '%v'", v[0], v[2], v[3], match.Params[v[2]])
+		}
+	}
+
+	// an unconstrained route cannot be followed by a route with the same shape
+	app.Router.Routes, _ = parseRoutes("memory", "", "GET /a/:x A.B\nGET /a/:y<int> A.C\n", false, app)
+	if err := app.Router.updateTree(); err == nil {
+		t.Fatal("expected a duplicate path error")
+	}
+	app.Router.Routes, _ = parseRoutes("memory", "", "GET /a/:x<[z-a]> A.B\n", false, app)
+	if err := app.Router.updateTree(); err == nil {
+		t.Fatal("expected an invalid constraint error")
+	}
+	if _, _, err := parseRoutePath("/a/:x<int/b"); err == nil || !strings.Contains(err.Error(), "`:x<int/b` is not closed") {
+		t.Fatalf("expected a not closed error, got %v", err)
+	}
+}
+
+func TestRouteMethodNotAllowed(t *testing.T) {
+	app := NewApp()
+	app.RegisterController(&filterTestController{})
+	app.AddRouteLine("GET /users filterTestController.Users")
+	app.AddRouteLine("POST /users filterTestController.Users")
+	app.AddRouteLine("DELETE /users/:id<int> filterTestController.Users")
+
+	w, r := testRequest("PUT", "/users")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %v", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Fatalf("unexpected Allow header '%v'", allow)
+	}
+
+	w, r = testRequest("OPTIONS", "/users/5")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %v", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "DELETE, OPTIONS" {
+		t.Fatalf("unexpected Allow header '%v'", allow)
+	}
+
+	// constraints are respected
+	_, r = testRequest("OPTIONS", "/users/abc")
+	if allowed := app.Router.AllowedMethods(r); allowed != nil {
+		t.Fatalf("expected no allowed methods, got %v", allowed)
+	}
+
+	// app middlewares and filters run first (e.g. CORS preflights)
+	app.Use(FromHTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	app.Filters = []Filter{func(in *In) bool {
+		in.W.Header().Set("X-Filter", "1")
+		return true
+	}}
+	w, r = testRequest("OPTIONS", "/users")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Allow") != "" {
+		t.Fatalf("the middleware must answer the preflight: %v %v", w.Code, w.Header())
+	}
+	w, r = testRequest("PUT", "/users")
+	app.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("X-Filter") != "1" {
+		t.Fatalf("unexpected 405 response: %v %v", w.Code, w.Header())
+	}
+}
+
+func TestRoutesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboots_routes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routesPath := filepath.Join(dir, "Routes.cfg")
+	ioutil.WriteFile(routesPath, []byte("GET /users filterTestController.Users\n"), 0777)
+
+	app := NewApp()
+	app.Config.RoutesConfigPath = routesPath
+	app.Config.WatchRoutesFile = true
+	app.RegisterController(&filterTestController{})
+	if err := app.loadRoutesNew(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.AddRouteLine("POST /users filterTestController.Users"); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid routes keep the current routing table
+	ioutil.WriteFile(routesPath, []byte("GET /public filterTestController.Nope\n"), 0777)
+	if err := app.Router.Refresh(); err == nil {
+		t.Fatal("Refresh should fail on a missing action")
+	}
+	_, r := testRequest("GET", "/users")
+	if app.Router.Route(r) == nil {
+		t.Fatal("the previous routes should be kept after a failed Refresh")
+	}
+
+	// the watcher picks up valid changes
+	ioutil.WriteFile(routesPath, []byte("GET /public filterTestController.Public\n"), 0777)
+	_, r = testRequest("GET", "/public")
+	for i := 0; i < 50 && app.Router.Route(r) == nil; i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if app.Router.Route(r) == nil {
+		t.Fatal("routes file change was not reloaded")
+	}
+	_, r = testRequest("POST", "/users")
+	if app.Router.Route(r) == nil {
+		t.Fatal("routes added with AddRouteLine should be kept after a reload")
+	}
+}
+
+func TestReverseRouting(t *testing.T) {
+	app := NewApp()
+	app.RegisterController(&filterTestController{})
+	app.AddRouteLine("GET /users/:id<int> filterTestController.Users")
+	app.AddRouteLine("GET /files/:name.json filterTestController.Public")
+
+	args := map[string]string{"id": "5", "page": "2"}
+	def := app.Router.Reverse("filterTestController.Users", args)
+	if def == nil || def.Url != "/users/5?page=2" {
+		t.Fatalf("unexpected reverse route %v", def)
+	}
+	if len(args) != 2 {
+		t.Fatal("Reverse should not modify argValues")
+	}
+	if u, err := app.URL("filterTestController.Public", "name", "a b"); err != nil || u != "/files/a%20b.json" {
+		t.Fatalf("unexpected url %v %v", u, err)
+	}
+	if _, err := app.URL("filterTestController.Nope"); err == nil {
+		t.Fatal("url should fail on unknown actions")
+	}
+
+	// url template func
+	app.builtinURLFunc = true
+	tpl := template.Must(template.New("t").Funcs(template.FuncMap{"url": app.URL}).Parse(`{{url "filterTestController.Users" "id" .}}`))
+	if err := app.checkTemplateURLs(tpl); err != nil {
+		t.Fatal(err)
+	}
+	rw := &MockResponseWriter{}
+	if err := tpl.Execute(rw, 7); err != nil || rw.StringBody() != "/users/7" {
+		t.Fatalf("unexpected template output %v %v", rw.StringBody(), err)
+	}
+	tpl = template.Must(template.New("t").Funcs(template.FuncMap{"url": app.URL}).Parse(`{{if .}}<a href="{{url "Posts.Show" "id" .}}">{{end}}`))
+	if err := app.checkTemplateURLs(tpl); err == nil {
+		t.Fatal("checkTemplateURLs should fail on unknown actions")
+	}
+
+	// redirect to action
+	w, r := testRequest("GET", "/")
+	in := &In{R: r, W: w, App: app}
+	in.RedirectToAction("filterTestController.Users", map[string]string{"id": "9"}).Render(w)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/users/9" {
+		t.Fatalf("unexpected redirect %v %v", w.Code, w.Header().Get("Location"))
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RedirectToAction should panic on unknown actions")
+		}
+	}()
+	in.RedirectToAction("Posts.Show", nil)
+}
+
+type resourceController struct {
+	Controller
+}
+
+func (c *resourceController) Index(in *In) *Out {
+	return in.OutputString("index " + in.Params["user_id"])
+}
+
+func (c *resourceController) New(in *In) *Out {
+	return in.OutputString("new")
+}
+
+func (c *resourceController) Create(in *In) *Out {
+	return in.OutputString("create")
+}
+
+func (c *resourceController) Show(in *In) *Out {
+	return in.OutputString("show " + in.Params["user_id"] + " " + in.Params["id"])
+}
+
+func (c *resourceController) Destroy(in *In) *Out {
+	return in.OutputString("destroy " + in.Params["id"])
+}
+
+type typedResourceController struct {
+	Controller
+}
+
+func (c *typedResourceController) Show(in *In, id int64) *Out {
+	return in.OutputString(fmt.Sprint("show ", id+1))
+}
+
+type nestedResourceController struct {
+	Controller
+}
+
+func (c *nestedResourceController) Edit(in *In, userID string, id int64) *Out {
+	return in.OutputString(fmt.Sprint("edit ", userID, " ", id))
+}
+
+func TestRouteResources(t *testing.T) {
+	app := NewApp()
+	app.RegisterController(&resourceController{})
+	app.Router = NewRouter(app, "memory")
+	routes, err := parseRoutes("memory", "", `RESOURCE /posts resourceController
+RESOURCE /users resourceController {
+	RESOURCE /posts resourceController
+}
+`, true, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Edit and Update (PUT and PATCH) are skipped
+	if len(routes) != 15 {
+		t.Fatalf("expected 15 routes, got %v", len(routes))
+	}
+	app.Router.Routes = routes
+	if err := app.Router.updateTree(); err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]string{
+		{"GET", "/posts", "200", "index "},
+		{"GET", "/posts/new", "200", "new"},
+		{"POST", "/posts", "200", "create"},
+		{"GET", "/posts/7", "200", "show  7"},
+		{"DELETE", "/posts/7", "200", "destroy 7"},
+		{"PUT", "/posts/7", "405", ""},
+		{"GET", "/users/3/posts", "200", "index 3"},
+		{"GET", "/users/3/posts/9", "200", "show 3 9"},
+	}
+	for _, v := range cases {
+		w, r := testRequest(v[0], v[1])
+		app.ServeHTTP(w, r)
+		if fmt.Sprint(w.Code) != v[2] {
+			t.Fatalf("%v %v: expected status %v, got %v", v[0], v[1], v[2], w.Code)
+		}
+		if v[2] == "200" && w.Body.String() != v[3] {
+			t.Fatalf("%v %v: expected '%v', got '%v'", v[0], v[1], v[3], w.Body.String())
+		}
+	}
+	if u := app.Router.Reverse("resourceController.Show", map[string]string{"user_id": "3", "id": "9"}); u == nil || u.Url != "/users/3/posts/9" {
+		t.Fatalf("unexpected reverse: %v", u)
+	}
+
+	// typed actions are bound to the last wildcards
+	app.RegisterController(&typedResourceController{})
+	app.RegisterController(&nestedResourceController{})
+	routes, err = parseRoutes("memory", "", `RESOURCE /items typedResourceController
+RESOURCE /users resourceController {
+	RESOURCE /items typedResourceController
+	RESOURCE /notes nestedResourceController
+}
+`, true, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Router.Routes = routes
+	if err := app.Router.updateTree(); err != nil {
+		t.Fatal(err)
+	}
+	for path, body := range map[string]string{"/items/7": "show 8", "/users/3/items/9": "show 10", "/users/3/notes/9/edit": "edit 3 9"} {
+		w, r := testRequest("GET", path)
+		app.ServeHTTP(w, r)
+		if w.Code != 200 || w.Body.String() != body {
+			t.Fatalf("%v: expected '%v', got %v '%v'", path, body, w.Code, w.Body.String())
+		}
+	}
+	if resourceParamName("/categories") != "category_id" || resourceParamName("/address") != "address_id" {
+		t.Fatal("unexpected resource param names")
+	}
+	if _, err := parseRoutes("memory", "", "RESOURCE posts resourceController\n", false, app); err == nil {
+		t.Fatal("RESOURCE without a leading slash must fail")
+	}
+}
+
+func TestHandlerRoutes(t *testing.T) {
+	app := NewApp()
+	app.RegisterHandler("metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+	app.RegisterFilter("auth", func(in *In) bool {
+		if in.R.Header.Get("Authorization") == "" {
+			in.W.WriteHeader(401)
+			return false
+		}
+		return true
+	})
+	app.Router = NewRouter(app, "memory")
+	routes, err := parseRoutes("memory", "", "GET /metrics handler:metrics [auth]\n", true, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Router.Routes = routes
+	app.Router.updateTree()
+	if err := app.Handle("GET", "/files/*path", http.StripPrefix("/files", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file " + r.URL.Path))
+	}))); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Router.Routes) != 2 || app.Router.Routes[1].Handler == nil {
+		t.Fatal("App.Handle must add a route")
+	}
+
+	w, r := testRequest("GET", "/metrics")
+	app.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Fatalf("the route filter must run, got %v", w.Code)
+	}
+	w, r = testRequest("GET", "/metrics")
+	r.Header.Set("Authorization", "x")
+	app.ServeHTTP(w, r)
+	if w.Body.String() != "metrics" {
+		t.Fatalf("unexpected body '%v'", w.Body.String())
+	}
+	w, r = testRequest("GET", "/files/a/b.txt")
+	app.ServeHTTP(w, r)
+	if w.Body.String() != "file /a/b.txt" {
+		t.Fatalf("unexpected body '%v'", w.Body.String())
+	}
+
+	// Handle before and after loading the routes file
+	dir, err := ioutil.TempDir("", "goboots_routes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routesPath := filepath.Join(dir, "Routes.cfg")
+	ioutil.WriteFile(routesPath, []byte("GET /metrics handler:metrics\n"), 0777)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	app.Router = nil
+	app.Config.RoutesConfigPath = routesPath
+	if err := app.Handle("GET", "/health", ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadRoutesNew(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Handle("GET", "/ready", ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Router.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/metrics", "/health", "/ready"} {
+		_, r = testRequest("GET", p)
+		if app.Router.Route(r) == nil {
+			t.Fatalf("%v is not routed after Refresh", p)
+		}
+	}
+
+	if _, err := parseRoutes("memory", "", "GET /x handler:missing\n", true, app); err == nil {
+		t.Fatal("unregistered handlers must fail validation")
+	}
+}
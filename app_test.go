@@ -2,11 +2,14 @@ package goboots
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -212,3 +215,329 @@ func TestTemplateProcessor(t *testing.T) {
 		t.Fatalf("Template is \n'%v'\nshould be:\n'%v'\n", str0, str1)
 	}
 }
+
+type typedArgsController struct {
+	Controller
+}
+
+func (c *typedArgsController) Show(in *In, id int64, page int, draft bool) *Out {
+	return in.OutputString(fmt.Sprint(id, " ", page, " ", draft))
+}
+
+func (c *typedArgsController) Fixed(in *In, kind string, id uint) *Out {
+	return in.OutputString(fmt.Sprint(kind, " ", id))
+}
+
+func TestTypedActionArgs(t *testing.T) {
+	app := NewApp()
+	app.RegisterController(&typedArgsController{})
+	app.AddRouteLine("GET /posts/:id typedArgsController.Show(:id, :page, :draft)")
+	app.AddRouteLine(`GET /fixed/:id typedArgsController.Fixed("post", :id)`)
+
+	cases := [][]string{
+		{"/posts/42?page=3&draft=true", "200", "42 3 true"},
+		{"/posts/42", "200", "42 0 false"},
+		{"/posts/abc", "400", ""},
+		{"/posts/42?page=x", "400", ""},
+		{"/fixed/7", "200", "post 7"},
+		{"/fixed/-7", "400", ""},
+	}
+	for _, v := range cases {
+		w, r := testRequest("GET", v[0])
+		app.ServeHTTP(w, r)
+		if fmt.Sprint(w.Code) != v[1] {
+			t.Fatalf("%v: expected status %v, got %v", v[0], v[1], w.Code)
+		}
+		if v[1] == "200" && w.Body.String() != v[2] {
+			t.Fatalf("%v: expected '%v', got '%v'", v[0], v[2], w.Body.String())
+		}
+	}
+
+	route := NewRoute("GET", "/posts/:id", "typedArgsController.Show", ":id", "memory", 0, false, app)
+	if err := validateRoute(route); err == nil {
+		t.Fatal("validateRoute should fail when the route does not declare every arg")
+	}
+	_, _, _, fixedArgs, _, _, found, _, _ := routeParseLine(`GET /a A.B( "x" , :id,:page )`)
+	if !found || strings.Join(routeArgNames(fixedArgs), ",") != ",id,page" {
+		t.Fatalf("unexpected arg names for '%v'", fixedArgs)
+	}
+}
+
+type panicController struct {
+	Controller
+}
+
+func (c *panicController) Action(in *In) *Out {
+	panic("boom")
+}
+
+func (c *panicController) JSON(in *In) *Out {
+	// channels can't be marshaled; Render answers 500
+	return in.OutputJSON(make(chan int))
+}
+
+func TestPanicRecovery(t *testing.T) {
+	app := NewApp()
+	app.Logger = log.New(ioutil.Discard, "", 0)
+	app.RegisterController(&panicController{})
+	app.AddRouteLine("GET /panic/:id panicController.Action")
+	app.AddRouteLine("GET /json panicController.JSON")
+
+	for _, path := range []string{"/panic/1", "/json"} {
+		w, r := testRequest("GET", path)
+		app.ServeHTTP(w, r)
+		if w.Code != 500 {
+			t.Fatalf("%v: expected status 500, got %v", path, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "goroutine") {
+			t.Fatalf("%v: the stack must not leak outside verbose mode", path)
+		}
+	}
+
+	app.Config.Verbose = true
+	w, r := testRequest("GET", "/panic/1")
+	app.ServeHTTP(w, r)
+	body := w.Body.String()
+	if w.Code != 500 || !strings.Contains(body, "panic: boom") || !strings.Contains(body, "panicController.Action") || !strings.Contains(body, "goroutine") {
+		t.Fatalf("unexpected debug page (%v): %v", w.Code, body)
+	}
+
+	// the error page is not compressed by CompressFilter
+	app.Config.Verbose = false
+	app.Config.GZipDynamic = true
+	app.Filters = []Filter{CompressFilter}
+	w, r = testRequest("GET", "/panic/1")
+	r.Header.Set("Accept-Encoding", "gzip")
+	app.ServeHTTP(w, r)
+	if w.Code != 500 || w.Header().Get("Content-Encoding") != "" || !strings.HasPrefix(w.Body.String(), "Internal Server Error") {
+		t.Fatalf("unexpected compressed error response (%v): %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+type errorsController struct {
+	Controller
+}
+
+func (c *errorsController) Show(in *In) (*Out, error) {
+	switch in.Params["id"] {
+	case "1":
+		return in.OutputString("post 1"), nil
+	case "2":
+		return nil, NewHTTPError(404, "post not found", errors.New("sql: no rows in result set"))
+	}
+	return nil, errors.New("database is down")
+}
+
+func TestActionErrors(t *testing.T) {
+	app := NewApp()
+	app.Logger = log.New(ioutil.Discard, "", 0)
+	app.RegisterController(&errorsController{})
+	app.AddRouteLine("GET /posts/:id errorsController.Show")
+
+	cases := []struct {
+		path, accept string
+		status       int
+		body         string
+	}{
+		{"/posts/1", "", 200, "post 1"},
+		{"/posts/2", "", 404, "post not found\n"},
+		{"/posts/3", "", 500, "Internal Server Error\n"},
+		{"/posts/2", "application/json", 404, `{"type":"about:blank","title":"Not Found","status":404,"detail":"post not found","instance":"/posts/2"}`},
+		{"/posts/3", "application/json", 500, `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/posts/3"}`},
+	}
+	for _, v := range cases {
+		w, r := testRequest("GET", v.path)
+		if v.accept != "" {
+			r.Header.Set("Accept", v.accept)
+		}
+		app.ServeHTTP(w, r)
+		if w.Code != v.status || w.Body.String() != v.body {
+			t.Fatalf("%v (%v): expected %v '%v', got %v '%v'", v.path, v.accept, v.status, v.body, w.Code, w.Body.String())
+		}
+	}
+
+	app.ErrorFunc = func(in *In, err error) {
+		in.W.WriteHeader(418)
+	}
+	w, r := testRequest("GET", "/posts/2")
+	app.ServeHTTP(w, r)
+	if w.Code != 418 {
+		t.Fatalf("ErrorFunc was not used (%v)", w.Code)
+	}
+}
+
+type middlewareController struct {
+	Controller
+	trace *[]string
+}
+
+func (c *middlewareController) Init() {
+	c.Use(func(next InFunc) InFunc {
+		return func(in *In) {
+			*c.trace = append(*c.trace, "controller")
+			next(in)
+		}
+	})
+}
+
+func (c *middlewareController) Index(in *In) *Out {
+	*c.trace = append(*c.trace, "action")
+	return in.OutputString("ok")
+}
+
+func TestMiddleware(t *testing.T) {
+	trace := make([]string, 0)
+	app := NewApp()
+	app.RegisterController(&middlewareController{trace: &trace})
+	app.AddRouteLine("GET / middlewareController.Index")
+	app.Use(func(next InFunc) InFunc {
+		return func(in *In) {
+			trace = append(trace, "app before")
+			w, r := in.W, in.R
+			next(in)
+			if in.W != w || in.R != r {
+				trace = append(trace, "writer not restored")
+			}
+			trace = append(trace, "app after")
+		}
+	}, FromHTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Std", "1")
+			next.ServeHTTP(struct{ http.ResponseWriter }{w}, r.WithContext(r.Context()))
+		})
+	}))
+	app.Filters = append(app.Filters, func(in *In) bool {
+		trace = append(trace, "filter")
+		return true
+	})
+
+	w, r := testRequest("GET", "/")
+	app.ServeHTTP(w, r)
+	if w.Body.String() != "ok" || w.Header().Get("X-Std") != "1" {
+		t.Fatalf("unexpected response: %v %v", w.Body.String(), w.Header())
+	}
+	if v := strings.Join(trace, ","); v != "app before,filter,controller,action,app after" {
+		t.Fatalf("unexpected middleware order: %v", v)
+	}
+
+	// goboots middleware on a plain net/http handler
+	h := app.HTTPMiddleware(func(next InFunc) InFunc {
+		return func(in *In) {
+			in.W.Header().Set("X-Goboots", "1")
+			next(in)
+		}
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	w, r = testRequest("GET", "/plain")
+	h.ServeHTTP(w, r)
+	if w.Body.String() != "plain" || w.Header().Get("X-Goboots") != "1" {
+		t.Fatalf("unexpected response: %v %v", w.Body.String(), w.Header())
+	}
+}
+
+type scopedController struct {
+	Controller
+	Greeting string
+	name     string
+}
+
+func (c *scopedController) Hello(in *In) *Out {
+	c.name = in.Params["name"]
+	time.Sleep(time.Millisecond)
+	return in.OutputString(c.Greeting + " " + c.name)
+}
+
+func TestScopedController(t *testing.T) {
+	app := NewApp()
+	proto := &scopedController{Greeting: "hello"}
+	app.RegisterScopedController(proto)
+	app.AddRouteLine("GET /hello/:name scopedController.Hello")
+
+	errc := make(chan error, 20)
+	for i := 0; i < cap(errc); i++ {
+		go func(i int) {
+			w, r := testRequest("GET", fmt.Sprint("/hello/", i))
+			app.ServeHTTP(w, r)
+			if v := w.Body.String(); v != fmt.Sprint("hello ", i) {
+				errc <- fmt.Errorf("expected 'hello %v', got '%v'", i, v)
+				return
+			}
+			errc <- nil
+		}(i)
+	}
+	for i := 0; i < cap(errc); i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if proto.name != "" {
+		t.Fatal("the registered controller must not be used by requests")
+	}
+}
+
+type problemController struct {
+	Controller
+}
+
+func (c *problemController) Init() {
+	c.ProblemJSON = true
+}
+
+func (c *problemController) Show(in *In) (*Out, error) {
+	return nil, &HTTPError{Status: 402, Message: "not enough credit", Type: "https://example.com/probs/out-of-credit"}
+}
+
+func TestProblemJSON(t *testing.T) {
+	app := NewApp()
+	app.Logger = log.New(ioutil.Discard, "", 0)
+	app.RegisterController(&problemController{})
+	app.RegisterController(&errorsController{})
+	app.AddRouteLine("GET /api/posts/:id errorsController.Show [problem]")
+	app.AddRouteLine("GET /html/posts/:id errorsController.Show")
+	app.AddRouteLine("GET /credit problemController.Show")
+
+	cases := []struct {
+		path, accept string
+		status       int
+		ctype, body  string
+	}{
+		// per route
+		{"/api/posts/2", "", 404, "application/problem+json", `{"type":"about:blank","title":"Not Found","status":404,"detail":"post not found","instance":"/api/posts/2","request_id":"abc"}`},
+		// per controller
+		{"/credit", "", 402, "application/problem+json", `{"type":"https://example.com/probs/out-of-credit","title":"Payment Required","status":402,"detail":"not enough credit","instance":"/credit","request_id":"abc"}`},
+		// by the Accept header
+		{"/html/posts/2", "application/problem+json", 404, "application/problem+json", `{"type":"about:blank","title":"Not Found","status":404,"detail":"post not found","instance":"/html/posts/2","request_id":"abc"}`},
+		{"/html/posts/2", "text/html", 404, "text/plain; charset=utf-8", "post not found\n"},
+	}
+	for _, v := range cases {
+		w, r := testRequest("GET", v.path)
+		r.Header.Set("X-Request-ID", "abc")
+		if v.accept != "" {
+			r.Header.Set("Accept", v.accept)
+		}
+		app.ServeHTTP(w, r)
+		if w.Code != v.status || w.Header().Get("Content-Type") != v.ctype || w.Body.String() != v.body {
+			t.Fatalf("%v (%v): unexpected response %v %v '%v'", v.path, v.accept, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	// validation errors are listed
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/users", nil)
+	r.Header.Set("Accept", "application/json")
+	app.doHTTPError(w, r, 422, httpErrorOf(ValidationErrors{{Field: "email", Rule: "email", Message: "email must be a valid email address"}}))
+	if !strings.Contains(w.Body.String(), `"errors":[{"field":"email","rule":"email","message":"email must be a valid email address"}]`) {
+		t.Fatalf("missing validation errors: %v", w.Body.String())
+	}
+
+	app.HTTPErrorFunc = func(w http.ResponseWriter, r *http.Request, err int) {
+		w.WriteHeader(err)
+		w.Write([]byte("custom"))
+	}
+	w, r = testRequest("GET", "/api/posts/2")
+	app.ServeHTTP(w, r)
+	if w.Code != 404 || w.Body.String() != "custom" {
+		t.Fatalf("HTTPErrorFunc must override problem+json: %v '%v'", w.Code, w.Body.String())
+	}
+}
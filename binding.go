@@ -0,0 +1,469 @@
+package goboots
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// isBindableKind checks if an action arg of this kind can be bound
+// from a request value.
+func isBindableKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// bindString converts a request value to the type t. An empty value
+// results in the zero value of t.
+func bindString(raw string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if len(raw) < 1 {
+		return v, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(n)
+	default:
+		return v, fmt.Errorf("cannot bind %s", t.String())
+	}
+	return v, nil
+}
+
+// bindActionArgs builds the typed args of a controller method. Args are
+// taken (by position) from the route: a name binds the route param, query
+// or form value with that name and an unnamed arg uses the fixed value.
+func (in *In) bindActionArgs(m controllerMethod, argNames, fixedParams []string) ([]reflect.Value, error) {
+	vals := make([]reflect.Value, len(m.Args))
+	for i, t := range m.Args {
+		var name, raw string
+		if i < len(argNames) {
+			name = argNames[i]
+		}
+		if len(name) > 0 {
+			if v, ok := in.Params[name]; ok {
+				raw = v
+			} else if in.R != nil {
+				raw = in.R.FormValue(name)
+			}
+		} else if i < len(fixedParams) {
+			raw = fixedParams[i]
+		}
+		v, err := bindString(raw, t)
+		if err != nil {
+			if len(name) < 1 {
+				name = strconv.Itoa(i)
+			}
+			return nil, fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// defaultMaxMemory is the amount of a multipart body kept in memory
+// (the rest goes to temp files).
+const defaultMaxMemory = 32 << 20
+
+// FieldError is a validation error of a single struct field.
+type FieldError struct {
+	Field   string `json:"field"`           // e.g. email
+	Rule    string `json:"rule"`            // e.g. required, min, email
+	Param   string `json:"param,omitempty"` // e.g. 3 for min=3
+	Message string `json:"message"`         // e.g. email must be a valid email address
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors is the list of field errors returned by Bind and
+// Validate. It can be rendered by templates and OutputJSON.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Has checks if the field has an error (useful in templates).
+func (e ValidationErrors) Has(field string) bool {
+	return e.Get(field) != nil
+}
+
+// Get returns the first error of the field or nil.
+func (e ValidationErrors) Get(field string) *FieldError {
+	for i := range e {
+		if e[i].Field == field {
+			return &e[i]
+		}
+	}
+	return nil
+}
+
+// Map returns the first error message of every field.
+func (e ValidationErrors) Map() map[string]string {
+	m := make(map[string]string, len(e))
+	for _, v := range e {
+		if _, ok := m[v.Field]; !ok {
+			m[v.Field] = v.Message
+		}
+	}
+	return m
+}
+
+// Bind decodes the request into v (a pointer to a struct) and validates it.
+// The decoder is picked by the Content-Type: a registered codec (e.g. JSON,
+// XML, MessagePack), urlencoded or multipart forms (the query string is
+// used when there's no body). Form fields are matched by the `form` tag,
+// then the `json` tag, then the field name. Validation rules are set with the `validate` tag:
+//
+//	Email string `form:"email" validate:"required,email"`
+//
+// A ValidationErrors is returned if the decoded value is invalid.
+func (in *In) Bind(v interface{}) error {
+	if err := in.ReqBody().Decode(v); err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// Decode decodes the request body into v, picking the decoder by
+// the request Content-Type. Malformed bodies return a 400 HTTPError and
+// unsupported content types a 415.
+func (inbw *InBodyWrapper) Decode(v interface{}) error {
+	ctype := inbw.R.Header.Get("Content-Type")
+	mtype := ""
+	if len(ctype) > 0 {
+		var err error
+		if mtype, _, err = mime.ParseMediaType(ctype); err != nil {
+			return NewHTTPError(http.StatusBadRequest, "invalid content type: "+err.Error(), err)
+		}
+	}
+	if mtype == "application/x-www-form-urlencoded" || mtype == "multipart/form-data" || mtype == "" {
+		return inbw.UnmarshalForm(v)
+	}
+	if codec := GetCodec(mtype); codec != nil {
+		if err := inbw.unmarshal(codec, v); err != nil {
+			if isBodyTooLarge(err) {
+				return NewHTTPError(http.StatusRequestEntityTooLarge, "", err)
+			}
+			return badRequest(err)
+		}
+		return nil
+	}
+	return NewHTTPError(http.StatusUnsupportedMediaType, "unsupported content type: "+mtype, nil)
+}
+
+// badRequest makes err a 400 HTTPError (HTTPErrors are kept as is).
+func badRequest(err error) error {
+	if _, ok := err.(*HTTPError); ok {
+		return err
+	}
+	return NewHTTPError(http.StatusBadRequest, err.Error(), err)
+}
+
+// UnmarshalForm decodes the form values (urlencoded, multipart and the
+// query string) into v, a pointer to a struct. Multipart files are bound
+// to *multipart.FileHeader and []*multipart.FileHeader fields.
+func (inbw *InBodyWrapper) UnmarshalForm(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("UnmarshalForm: v must be a pointer to a struct")
+	}
+	var files map[string][]*multipart.FileHeader
+	if strings.HasPrefix(inbw.R.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := parseMultipartForm(inbw.R); err != nil {
+			return err
+		}
+		if inbw.R.MultipartForm != nil {
+			files = inbw.R.MultipartForm.File
+		}
+	} else if err := inbw.R.ParseForm(); err != nil {
+		return badRequest(err)
+	}
+	if err := bindForm(rv.Elem(), inbw.R.Form, files); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+var fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+
+func bindForm(sv reflect.Value, form url.Values, files map[string][]*multipart.FileHeader) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		fv := sv.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := bindForm(fv, form, files); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name := fieldName(f)
+		if name == "-" {
+			continue
+		}
+		switch {
+		case f.Type == fileHeaderType:
+			if fh := files[name]; len(fh) > 0 {
+				fv.Set(reflect.ValueOf(fh[0]))
+			}
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem() == fileHeaderType:
+			if fh := files[name]; len(fh) > 0 {
+				fv.Set(reflect.ValueOf(fh))
+			}
+		case f.Type.Kind() == reflect.Slice && isBindableKind(f.Type.Elem().Kind()):
+			vals, ok := form[name]
+			if !ok {
+				continue
+			}
+			slice := reflect.MakeSlice(f.Type, len(vals), len(vals))
+			for j, raw := range vals {
+				v, err := bindString(raw, f.Type.Elem())
+				if err != nil {
+					return fmt.Errorf("invalid value for %s: %v", name, err)
+				}
+				slice.Index(j).Set(v)
+			}
+			fv.Set(slice)
+		case isBindableKind(f.Type.Kind()):
+			vals, ok := form[name]
+			if !ok || len(vals) < 1 {
+				continue
+			}
+			v, err := bindString(vals[0], f.Type)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %v", name, err)
+			}
+			fv.Set(v)
+		}
+	}
+	return nil
+}
+
+// fieldName is the request name of a struct field: the `form` tag,
+// the `json` tag or the field name.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"form", "json"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; len(name) > 0 {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// Validate checks the `validate` tags of v (a struct or a pointer to one).
+// Rules are comma separated: required, min=N, max=N, len=N, email, url,
+// oneof=a b c, alpha, alphanum, numeric. min, max and len check the length
+// of strings and slices and the value of numbers. Nested structs are
+// validated too. It returns nil or a ValidationErrors.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	errs := make(ValidationErrors, 0)
+	validateStruct(rv, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(sv reflect.Value, prefix string, errs *ValidationErrors) {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		fv := sv.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := prefix + fieldName(f)
+		if f.Anonymous {
+			name = prefix
+		}
+		if rules := f.Tag.Get("validate"); len(rules) > 0 && rules != "-" {
+			for _, rule := range strings.Split(rules, ",") {
+				rule = strings.TrimSpace(rule)
+				param := ""
+				if eq := strings.Index(rule, "="); eq != -1 {
+					rule, param = rule[:eq], rule[eq+1:]
+				}
+				if msg := validateRule(fv, rule, param); len(msg) > 0 {
+					*errs = append(*errs, FieldError{
+						Field:   name,
+						Rule:    rule,
+						Param:   param,
+						Message: name + " " + msg,
+					})
+					// one error per field
+					break
+				}
+			}
+		}
+		// nested structs
+		nv := fv
+		if nv.Kind() == reflect.Ptr && !nv.IsNil() {
+			nv = nv.Elem()
+		}
+		if nv.Kind() == reflect.Struct && nv.Type() != reflect.TypeOf(time.Time{}) && f.Type != fileHeaderType {
+			if f.Anonymous {
+				validateStruct(nv, prefix, errs)
+			} else {
+				validateStruct(nv, name+".", errs)
+			}
+		}
+	}
+}
+
+var (
+	emailRegexp    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	alphaRegexp    = regexp.MustCompile(`^[a-zA-Z]*$`)
+	alphanumRegexp = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
+	numericRegexp  = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]+$`)
+)
+
+// validateRule returns an error message if the rule fails.
+func validateRule(v reflect.Value, rule, param string) string {
+	switch rule {
+	case "required":
+		if isZeroValue(v) {
+			return "is required"
+		}
+		return ""
+	case "":
+		return ""
+	}
+	// the other rules only apply to values that were set
+	if isZeroValue(v) {
+		return ""
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch rule {
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "has an invalid " + rule + " rule"
+		}
+		var size float64
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			size = float64(utf8.RuneCountInString(v.String()))
+			unit = " characters long"
+		case reflect.Slice, reflect.Map, reflect.Array:
+			size = float64(v.Len())
+			unit = " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			size = v.Float()
+		default:
+			return ""
+		}
+		switch {
+		case rule == "min" && size < n:
+			return "must be at least " + param + unit
+		case rule == "max" && size > n:
+			return "must be at most " + param + unit
+		case rule == "len" && size != n:
+			return "must be exactly " + param + unit
+		}
+	case "email":
+		if v.Kind() == reflect.String && !emailRegexp.MatchString(v.String()) {
+			return "must be a valid email address"
+		}
+	case "url":
+		if v.Kind() == reflect.String {
+			u, err := url.Parse(v.String())
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return "must be a valid URL"
+			}
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		if StringIndexOf(strings.Fields(param), s) == -1 {
+			return "must be one of " + strings.Join(strings.Fields(param), ", ")
+		}
+	case "alpha":
+		if v.Kind() == reflect.String && !alphaRegexp.MatchString(v.String()) {
+			return "must contain only letters"
+		}
+	case "alphanum":
+		if v.Kind() == reflect.String && !alphanumRegexp.MatchString(v.String()) {
+			return "must contain only letters and numbers"
+		}
+	case "numeric":
+		if v.Kind() == reflect.String && !numericRegexp.MatchString(v.String()) {
+			return "must be a number"
+		}
+	default:
+		return "has an unknown validation rule " + rule
+	}
+	return ""
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return v.IsNil() || (v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Len() == 0)
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
@@ -0,0 +1,185 @@
+package goboots
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/shamaton/msgpack"
+)
+
+// Codec encodes and decodes the bodies of a media type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecs      = make(map[string]Codec)
+	codecsOrder = make([]string, 0) // default output preference
+	codecsMutex sync.RWMutex
+)
+
+// RegisterCodec registers (or replaces) the codec of a media type
+// (e.g. application/json). Registered codecs decode request bodies
+// (In.Bind, InBodyWrapper.Decode) and encode outputs (OutputEncoded).
+func RegisterCodec(mediaType string, codec Codec) {
+	mediaType = strings.ToLower(mediaType)
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	if _, ok := codecs[mediaType]; !ok {
+		codecsOrder = append(codecsOrder, mediaType)
+	}
+	codecs[mediaType] = codec
+}
+
+// GetCodec returns the codec of a media type (nil if none is registered).
+// Structured syntax suffixes (e.g. application/vnd.api+json) use the codec
+// of their base type.
+func GetCodec(mediaType string) Codec {
+	mediaType = strings.ToLower(mediaType)
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	if c, ok := codecs[mediaType]; ok {
+		return c
+	}
+	if plus := strings.LastIndex(mediaType, "+"); plus != -1 {
+		return codecs["application/"+mediaType[plus+1:]]
+	}
+	return nil
+}
+
+func marshalCodec(mediaType string, v interface{}) ([]byte, error) {
+	c := GetCodec(mediaType)
+	if c == nil {
+		return nil, errors.New("no codec registered for " + mediaType)
+	}
+	return c.Marshal(v)
+}
+
+// negotiateCodec picks the codec of the Accept header of r
+// (application/json if any type is accepted).
+func negotiateCodec(r *http.Request) (string, Codec) {
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	if len(accept) < 1 {
+		return "application/json", GetCodec("application/json")
+	}
+	for _, ar := range parseAccept(accept) {
+		switch {
+		case ar.mtype == "*/*" || ar.mtype == "application/*":
+			return "application/json", GetCodec("application/json")
+		case strings.HasSuffix(ar.mtype, "/*"):
+			codecsMutex.RLock()
+			for _, mtype := range codecsOrder {
+				if strings.HasPrefix(mtype, ar.mtype[:len(ar.mtype)-1]) {
+					codecsMutex.RUnlock()
+					return mtype, GetCodec(mtype)
+				}
+			}
+			codecsMutex.RUnlock()
+		default:
+			if c := GetCodec(ar.mtype); c != nil {
+				return ar.mtype, c
+			}
+		}
+	}
+	return "", nil
+}
+
+// OutputEncoded encodes v with the codec of the Accept header (e.g. JSON,
+// XML, MessagePack or Protocol Buffers). It answers 406 if no registered
+// codec is acceptable.
+func (in *In) OutputEncoded(v interface{}) *Out {
+	mtype, codec := negotiateCodec(in.R)
+	if codec == nil {
+		return in.OutputString(httpStatusText(406)).Status(406).Header("Vary", "Accept")
+	}
+	o := in.newOut(outEncoded)
+	o.contentObj = v
+	o.contentStr = mtype
+	o.codec = codec
+	return o.Header("Vary", "Accept")
+}
+
+// unmarshal decodes the request body with codec.
+func (inbw *InBodyWrapper) unmarshal(codec Codec, v interface{}) error {
+	if inbw.R.Body == nil {
+		return errors.New("request body is null")
+	}
+	defer inbw.R.Body.Close()
+	bs, err := ioutil.ReadAll(inbw.R.Body)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(bs, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// msgpackCodec uses the `msgpack` struct tags.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// protobufCodec only handles proto.Message values.
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.New("protobuf: value is not a proto.Message")
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.New("protobuf: value is not a proto.Message")
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func init() {
+	RegisterCodec("application/json", jsonCodec{})
+	RegisterCodec("application/xml", xmlCodec{})
+	RegisterCodec("text/xml", xmlCodec{})
+	RegisterCodec("application/msgpack", msgpackCodec{})
+	RegisterCodec("application/x-msgpack", msgpackCodec{})
+	RegisterCodec("application/vnd.msgpack", msgpackCodec{})
+	RegisterCodec("application/protobuf", protobufCodec{})
+	RegisterCodec("application/x-protobuf", protobufCodec{})
+	RegisterCodec("application/vnd.google.protobuf", protobufCodec{})
+}
@@ -211,6 +211,41 @@ func (s *SessFlash) All() map[string]interface{} {
 	return s.vals
 }
 
+// sessionFlashKey is the session data key of the flash messages that
+// must survive a redirect.
+const sessionFlashKey = "_goboots_flash"
+
+// keepFlash saves a flash message in the session data, so it's
+// available (in Flash) on the next request.
+func (s *Session) keepFlash(key string, val interface{}) {
+	if s.Data == nil {
+		s.Data = make(map[string]interface{})
+	}
+	m, ok := s.Data[sessionFlashKey].(map[string]interface{})
+	if !ok {
+		m = make(map[string]interface{})
+		s.Data[sessionFlashKey] = m
+	}
+	m[key] = val
+	s.Flush()
+}
+
+// loadFlash moves the flash messages saved by a redirect to Flash.
+func (s *Session) loadFlash() {
+	v, ok := s.Data[sessionFlashKey]
+	if !ok {
+		return
+	}
+	// session drivers may decode it as another map type (e.g. bson.M)
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		for _, k := range rv.MapKeys() {
+			s.Flash.Set(k.String(), rv.MapIndex(k).Interface())
+		}
+	}
+	delete(s.Data, sessionFlashKey)
+	s.Flush()
+}
+
 func (s *Session) DeleteData(key string) {
 	if s.Data != nil {
 		if _, ok := s.Data[key]; ok {
@@ -378,6 +413,7 @@ func (app *App) GetSession(w http.ResponseWriter, r *http.Request) *Session {
 				msession.w = w
 				msession.domain = app.Config.CookieDomain
 				msession.path = app.Config.CookiePath
+				msession.loadFlash()
 				return msession
 			}
 			app.Logger.Printf("SESSION ERROR :( [%s] %s\n", sid, err.Error())
@@ -3,7 +3,14 @@ package goboots
 import (
 	"bytes"
 	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/http"
+	"regexp"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 )
 
 func deepError(id int, str string, v ...interface{}) error {
@@ -34,3 +41,136 @@ func deepErr(err error) error {
 	}
 	return deepError(9999, err.Error())
 }
+
+// actionPanic is a panic recovered while a routed request was handled.
+type actionPanic struct {
+	value interface{}
+	stack []byte
+	match *RouteMatch
+}
+
+var templatePosRegexp = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// recoverPanic must be deferred by ServeHTTP. It logs the panic and answers
+// with a 500 (through DoHTTPError) or, in verbose mode, with a debug page.
+func (app *App) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	if rec == http.ErrAbortHandler {
+		// let net/http abort the response
+		panic(rec)
+	}
+	p, ok := rec.(*actionPanic)
+	if !ok {
+		p = &actionPanic{value: rec, stack: debug.Stack()}
+	}
+	app.Logger.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.String(), p.value, p.stack)
+	if app.Config.Verbose {
+		app.renderPanicPage(w, r, "panic", p)
+		return
+	}
+	app.DoHTTPError(w, r, 500)
+}
+
+type panicPageData struct {
+	Title    string // e.g. panic, render error
+	Value    string
+	Stack    string
+	Match    *RouteMatch
+	Request  *http.Request
+	Template string
+	Snippet  []panicPageLine
+}
+
+type panicPageLine struct {
+	N       int
+	Text    string
+	Current bool
+}
+
+// renderPanicPage answers with the debug page of a panic or an error
+// (e.g. a failed template; see Out.renderError).
+func (app *App) renderPanicPage(w http.ResponseWriter, r *http.Request, title string, p *actionPanic) {
+	data := &panicPageData{
+		Title:   title,
+		Value:   fmt.Sprint(p.value),
+		Stack:   string(p.stack),
+		Match:   p.match,
+		Request: r,
+	}
+	data.Template, data.Snippet = app.templateSnippet(data.Value, 5)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(500)
+	if err := panicPageTemplate.Execute(w, data); err != nil {
+		app.Logger.Println("panic page Execute error:", err.Error())
+	}
+}
+
+// templateSnippet finds a template position (template: name:line) in msg
+// and returns the lines around it.
+func (app *App) templateSnippet(msg string, around int) (string, []panicPageLine) {
+	m := templatePosRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return "", nil
+	}
+	tplInfo, ok := app.templateMap[m[1]]
+	if !ok {
+		return "", nil
+	}
+	line, _ := strconv.Atoi(m[2])
+	b, err := ioutil.ReadFile(tplInfo.path)
+	if err != nil {
+		return m[1], nil
+	}
+	lines := strings.Split(string(b), "\n")
+	snippet := make([]panicPageLine, 0, around*2+1)
+	for i := line - around; i <= line+around; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		snippet = append(snippet, panicPageLine{i, lines[i-1], i == line})
+	}
+	return m[1], snippet
+}
+
+var panicPageTemplate = htmltemplate.Must(htmltemplate.New("panic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>500 - Internal Server Error</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #b00; }
+pre { background: #f4f4f4; padding: 1em; overflow: auto; }
+table { border-collapse: collapse; }
+td { border-bottom: 1px solid #ddd; padding: 2px 8px; vertical-align: top; }
+.current { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}: {{.Value}}</h1>
+{{if .Match}}<h2>Route</h2>
+<table>
+<tr><td>Action</td><td>{{.Match.ControllerName}}.{{.Match.MethodName}}</td></tr>
+{{if .Match.Filters}}<tr><td>Filters</td><td>{{range .Match.Filters}}{{.}} {{end}}</td></tr>
+{{end}}
+{{range $k, $v := .Match.Params}}<tr><td>:{{$k}}</td><td>{{$v}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Snippet}}<h2>Template {{.Template}}</h2>
+<pre>{{range .Snippet}}{{if .Current}}<span class="current">{{end}}{{printf "%4d" .N}}  {{.Text}}{{if .Current}}</span>{{end}}
+{{end}}</pre>
+{{end}}<h2>Request</h2>
+<table>
+<tr><td>Method</td><td>{{.Request.Method}}</td></tr>
+<tr><td>URL</td><td>{{.Request.URL}}</td></tr>
+<tr><td>Host</td><td>{{.Request.Host}}</td></tr>
+<tr><td>Remote address</td><td>{{.Request.RemoteAddr}}</td></tr>
+{{range $k, $v := .Request.Header}}<tr><td>{{$k}}</td><td>{{range $v}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+<h2>Stack</h2>
+<pre>{{.Stack}}</pre>
+</body>
+</html>
+`))
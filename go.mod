@@ -11,6 +11,7 @@ require (
 	github.com/gabstv/go-uuid v0.0.0-20141202165402-ed3ca8a15a93
 	github.com/gabstv/i18n v0.0.0-20171123221505-9682600700aa
 	github.com/go-sql-driver/mysql v1.3.0 // indirect
+	github.com/golang/protobuf v1.3.5
 	github.com/gorilla/websocket v1.2.0
 	github.com/hoisie/redis v0.0.0-20160730154456-b5c6e81454e0
 	github.com/jmoiron/sqlx v0.0.0-20171129232851-99f3ad6d85ae
@@ -21,6 +22,7 @@ require (
 	github.com/monochromegane/go-gitignore v0.0.0-20160105113617-38717d0a108c
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/robfig/pathtree v0.0.0-20140121041023-41257a1839e9
+	github.com/shamaton/msgpack v1.2.1
 	github.com/stretchr/testify v1.1.4
 	golang.org/x/crypto v0.0.0-20171128194009-94eea52f7b74
 	golang.org/x/sys v0.0.0-20171130163741-8b4580aae2a0 // indirect
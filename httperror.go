@@ -0,0 +1,90 @@
+package goboots
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPError is an error with an HTTP status. Message is shown to the
+// client; Cause is only logged.
+//
+//	return nil, goboots.NewHTTPError(404, "post not found", err)
+type HTTPError struct {
+	Status  int    // e.g. 404
+	Message string // e.g. post not found
+	Cause   error  // e.g. sql: no rows in result set
+	Type    string // problem type URI of application/problem+json responses (optional)
+}
+
+func NewHTTPError(status int, message string, cause error) *HTTPError {
+	return &HTTPError{
+		Status:  status,
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.Status, e.Message)
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// httpErrorOf converts any error to an *HTTPError. Unknown errors
+// become a 500 without exposing their message.
+func httpErrorOf(err error) *HTTPError {
+	switch e := err.(type) {
+	case *HTTPError:
+		if len(e.Message) < 1 {
+			return &HTTPError{e.Status, httpStatusText(e.Status), e.Cause, e.Type}
+		}
+		return e
+	case ValidationErrors:
+		return &HTTPError{422, e.Error(), e, ""}
+	}
+	return &HTTPError{500, httpStatusText(500), err, ""}
+}
+
+func httpStatusText(status int) string {
+	if s, ok := httpErrorStrings[status]; ok {
+		return s
+	}
+	return http.StatusText(status)
+}
+
+// wantsJSON checks if the client prefers a JSON response.
+func wantsJSON(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	jsoni := strings.Index(accept, "json")
+	if jsoni == -1 {
+		return false
+	}
+	htmli := strings.Index(accept, "text/html")
+	return htmli == -1 || jsoni < htmli
+}
+
+// HandleError answers the request with err (returned by an action).
+// It calls app.ErrorFunc or DefaultErrorHandler.
+func (app *App) HandleError(in *In, err error) {
+	if app.ErrorFunc != nil {
+		app.ErrorFunc(in, err)
+		return
+	}
+	app.DefaultErrorHandler(in, err)
+}
+
+// DefaultErrorHandler logs err and renders it through DoHTTPError
+// (HTTPErrorFunc, application/problem+json or the error layouts).
+func (app *App) DefaultErrorHandler(in *In, err error) {
+	herr := httpErrorOf(err)
+	if herr.Status >= 500 || herr.Cause != nil {
+		app.Logger.Printf("%s %s: %s\n", in.R.Method, in.R.URL.String(), herr.Error())
+	}
+	app.doHTTPError(in.W, in.R, herr.Status, herr)
+}
@@ -0,0 +1,73 @@
+package goboots
+
+import (
+	"io"
+	"net/http"
+)
+
+// Middleware wraps the handling of a request. Unlike a Filter, it can run
+// code after next (when the action output was already rendered) and
+// replace in.W to wrap the response.
+//
+//	func Timer(next goboots.InFunc) goboots.InFunc {
+//		return func(in *goboots.In) {
+//			start := time.Now()
+//			next(in)
+//			in.App.Logger.Println(in.R.URL.Path, time.Since(start))
+//		}
+//	}
+type Middleware func(next InFunc) InFunc
+
+// chainMiddleware wraps h with mws. The first middleware is the outermost.
+func chainMiddleware(h InFunc, mws []Middleware) InFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// FromHTTPMiddleware adapts a standard net/http middleware
+// (func(http.Handler) http.Handler) to a Middleware. The writer and the
+// request of mw are only used until it returns.
+func FromHTTPMiddleware(mw func(http.Handler) http.Handler) Middleware {
+	return func(next InFunc) InFunc {
+		return func(in *In) {
+			w0, r0, bw0 := in.W, in.R, in.reqbodyw
+			defer func() {
+				in.W, in.R, in.reqbodyw = w0, r0, bw0
+			}()
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				in.W = w
+				if r != in.R {
+					in.R = r
+					in.reqbodyw = &InBodyWrapper{r}
+				}
+				next(in)
+			})).ServeHTTP(in.W, in.R)
+		}
+	}
+}
+
+// HTTPMiddleware adapts mw to a standard net/http middleware, so it can be
+// used with handlers that aren't routed by goboots (e.g. ServeMux).
+func (app *App) HTTPMiddleware(mw Middleware) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := mw(func(in *In) {
+			next.ServeHTTP(in.W, in.R)
+		})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			in := &In{
+				R:             r,
+				W:             w,
+				Params:        make(Params),
+				Content:       &InContent{},
+				LayoutContent: &InContent{},
+				App:           app,
+				closers:       make([]io.Closer, 0),
+				reqbodyw:      &InBodyWrapper{r},
+			}
+			defer in.closeall()
+			h(in)
+		})
+	}
+}
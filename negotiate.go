@@ -0,0 +1,122 @@
+package goboots
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatNone = iota
+	formatJSON
+	formatXML
+	formatHTML
+	formatText
+)
+
+// acceptRange is a media range of an Accept header.
+type acceptRange struct {
+	mtype string  // e.g. application/json, text/*
+	q     float64 // e.g. 0.9
+}
+
+// parseAccept parses an Accept header, sorted by preference.
+func parseAccept(header string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mtype := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(mtype) < 1 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, acceptRange{mtype, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// negotiateFormat picks the output format of a request: the .json/.xml
+// suffix of the path, then the Accept header. HTML is only available if
+// html is true.
+func negotiateFormat(r *http.Request, html bool) int {
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".json"):
+		return formatJSON
+	case strings.HasSuffix(r.URL.Path, ".xml"):
+		return formatXML
+	}
+	def := formatJSON
+	if html {
+		def = formatHTML
+	}
+	accept := r.Header.Get("Accept")
+	if len(accept) < 1 {
+		return def
+	}
+	for _, ar := range parseAccept(accept) {
+		switch {
+		case ar.mtype == "*/*":
+			return def
+		case ar.mtype == "application/json" || strings.HasSuffix(ar.mtype, "+json"):
+			return formatJSON
+		case ar.mtype == "application/xml" || ar.mtype == "text/xml" || (strings.HasSuffix(ar.mtype, "+xml") && ar.mtype != "application/xhtml+xml"):
+			return formatXML
+		case ar.mtype == "text/html" || ar.mtype == "application/xhtml+xml":
+			if html {
+				return formatHTML
+			}
+		case ar.mtype == "text/*":
+			if html {
+				return formatHTML
+			}
+			return formatText
+		case ar.mtype == "text/plain":
+			return formatText
+		case ar.mtype == "application/*":
+			return formatJSON
+		}
+	}
+	return formatNone
+}
+
+// Output renders value as JSON, XML, HTML or plain text, depending on the
+// path suffix (.json or .xml) and the Accept header of the request. HTML is
+// only available if a template is given (it's rendered like OutputTpl, with
+// value merged into Content and set as Content.Data). It answers 406 if no
+// format is acceptable.
+//
+//	return in.Output(posts, "posts/index.tpl")
+func (in *In) Output(value interface{}, template ...string) *Out {
+	var o *Out
+	switch negotiateFormat(in.R, len(template) > 0) {
+	case formatJSON:
+		o = in.OutputJSON(value)
+	case formatXML:
+		o = in.OutputXML(value)
+	case formatHTML:
+		in.Content.Merge(value)
+		in.Content.Set("Data", value)
+		o = in.OutputTpl(template[0])
+	case formatText:
+		o = in.OutputString(fmt.Sprint(value))
+	default:
+		return in.OutputString(httpStatusText(406)).Status(406).Header("Vary", "Accept")
+	}
+	return o.Header("Vary", "Accept")
+}
@@ -0,0 +1,78 @@
+package goboots
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Problem is an RFC 7807 error response (application/problem+json).
+type Problem struct {
+	Type      string           `json:"type"`               // e.g. about:blank
+	Title     string           `json:"title"`              // e.g. Not Found
+	Status    int              `json:"status"`             // e.g. 404
+	Detail    string           `json:"detail,omitempty"`   // e.g. post not found
+	Instance  string           `json:"instance,omitempty"` // e.g. /posts/5
+	RequestID string           `json:"request_id,omitempty"`
+	Errors    ValidationErrors `json:"errors,omitempty"`
+}
+
+type problemContextKey struct{}
+
+// ProblemJSON is a filter that makes the errors of the request
+// application/problem+json responses. It's registered as the "problem"
+// route filter:
+//
+//	GET /api/posts Posts.Index [problem]
+//
+// Controllers can set ProblemJSON to true instead.
+func ProblemJSON(in *In) bool {
+	if in.R != nil {
+		in.R = in.R.WithContext(context.WithValue(in.R.Context(), problemContextKey{}, true))
+		in.reqbodyw = &InBodyWrapper{in.R}
+	}
+	return true
+}
+
+// wantsProblem checks if the error of the request must be
+// application/problem+json (ProblemJSON or the Accept header).
+func wantsProblem(r *http.Request) bool {
+	if v, _ := r.Context().Value(problemContextKey{}).(bool); v {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/problem+json") || wantsJSON(r)
+}
+
+// newProblem builds the problem of an error response (herr may be nil).
+func newProblem(w http.ResponseWriter, r *http.Request, status int, herr *HTTPError) *Problem {
+	p := &Problem{
+		Type:     "about:blank",
+		Title:    httpStatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+	}
+	if herr != nil {
+		if len(herr.Type) > 0 {
+			p.Type = herr.Type
+		}
+		if herr.Message != p.Title {
+			p.Detail = herr.Message
+		}
+		if verrs, ok := herr.Cause.(ValidationErrors); ok {
+			p.Errors = verrs
+		}
+	}
+	if p.RequestID = r.Header.Get("X-Request-ID"); len(p.RequestID) < 1 {
+		p.RequestID = w.Header().Get("X-Request-ID")
+	}
+	return p
+}
+
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	b, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
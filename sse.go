@@ -0,0 +1,147 @@
+package goboots
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSSEHeartbeat is the interval of the heartbeat comments of
+// OutputSSE (they keep proxies from closing idle streams).
+var DefaultSSEHeartbeat = 15 * time.Second
+
+// Event is a Server-Sent Event.
+type Event struct {
+	ID    string        // sent back by the browser as Last-Event-ID on reconnect
+	Event string        // event type (e.g. notification); "message" if empty
+	Data  interface{}   // strings and []byte are sent as is; anything else as JSON
+	Retry time.Duration // reconnection time of the browser (if not 0)
+}
+
+// sseFieldReplacer removes the line terminators of the id and event
+// fields; sseLineReplacer turns the ones of the data into \n.
+var (
+	sseFieldReplacer = strings.NewReplacer("\r", "", "\n", "")
+	sseLineReplacer  = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+)
+
+// bytes formats the event in the text/event-stream format.
+func (e *Event) bytes() ([]byte, error) {
+	var b bytes.Buffer
+	if len(e.ID) > 0 {
+		b.WriteString("id: " + sseFieldReplacer.Replace(e.ID) + "\n")
+	}
+	if len(e.Event) > 0 {
+		b.WriteString("event: " + sseFieldReplacer.Replace(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	var data string
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		jb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = string(jb)
+	}
+	if e.Data != nil || (len(e.ID) < 1 && e.Retry == 0) {
+		for _, line := range strings.Split(sseLineReplacer.Replace(data), "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// OutputSSE streams the events of ch as Server-Sent Events until ch is
+// closed or the request is cancelled. Use LastEventID to resume a stream.
+//
+//	ch := make(chan goboots.Event)
+//	go notify(in.LastEventID(), ch) // must stop when in.R.Context() is done
+//	return in.OutputSSE(ch)
+func (in *In) OutputSSE(ch <-chan Event) *Out {
+	o := in.newOut(outSSE)
+	o.events = ch
+	o.heartbeat = DefaultSSEHeartbeat
+	return o
+}
+
+// Heartbeat sets the interval of the heartbeat comments of OutputSSE
+// (0 disables them).
+func (o *Out) Heartbeat(d time.Duration) *Out {
+	o.heartbeat = d
+	return o
+}
+
+// LastEventID returns the ID of the last event the browser received
+// (sent when an EventSource reconnects).
+func (in *In) LastEventID() string {
+	if in.R == nil {
+		return ""
+	}
+	return in.R.Header.Get("Last-Event-ID")
+}
+
+func (o *Out) renderSSE(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// nginx
+	h.Set("X-Accel-Buffering", "no")
+	o.writeStatus(w)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	var done <-chan struct{}
+	if o.ctx != nil {
+		done = o.ctx.Done()
+	}
+	var heartbeat <-chan time.Time
+	if o.heartbeat > 0 {
+		ticker := time.NewTicker(o.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flush()
+		case ev, ok := <-o.events:
+			if !ok {
+				return
+			}
+			b, err := ev.bytes()
+			if err != nil {
+				if o.app != nil {
+					o.app.Logger.Println("OutputSSE event error:", err.Error())
+				}
+				continue
+			}
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+			flush()
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package goboots
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage stores uploaded files (see UploadedFile.SaveTo).
+// Keys are slash separated, e.g. avatars/42.png.
+type Storage interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var errInvalidStorageKey = errors.New("invalid storage key")
+
+func cleanStorageKey(key string) (string, error) {
+	if len(key) < 1 || containsDotDot(key) {
+		return "", errInvalidStorageKey
+	}
+	key = strings.TrimPrefix(path.Clean("/"+strings.Replace(key, "\\", "/", -1)), "/")
+	if len(key) < 1 {
+		return "", errInvalidStorageKey
+	}
+	return key, nil
+}
+
+// LocalStorage stores the files in a folder.
+type LocalStorage struct {
+	Dir string
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := cleanStorageKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the file to a temp file and renames it, so readers never
+// see a partial file.
+func (s *LocalStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	fpath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fpath), ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fpath)
+}
+
+func (s *LocalStorage) Open(key string) (io.ReadCloser, error) {
+	fpath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fpath)
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	fpath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fpath)
+}
+
+// S3Storage stores the files in a bucket of an S3 compatible service
+// (AWS, MinIO, etc). Requests are path style and signed with AWS
+// Signature Version 4.
+type S3Storage struct {
+	Endpoint        string // e.g. https://s3.amazonaws.com, http://localhost:9000
+	Region          string // e.g. us-east-1
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client // http.DefaultClient if nil
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest("PUT", key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Open(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	key, err := cleanStorageKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.Endpoint, "/")+"/"+s3Escape(s.Bucket)+"/"+s3Escape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// do signs and sends the request. A 404 returns os.ErrNotExist.
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	region := s.Region
+	if len(region) < 1 {
+		region = "us-east-1"
+	}
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signV4(req, s.AccessKeyID, s.SecretAccessKey, region, "s3", time.Now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, errors.New("s3: " + req.Method + " " + req.URL.Path + ": " + resp.Status + " " + string(b))
+	}
+	return resp, nil
+}
+
+// signV4 adds the Authorization header of AWS Signature Version 4. All
+// the request headers are signed; X-Amz-Content-Sha256 must be set.
+func signV4(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzdate := now.Format("20060102T150405Z")
+	date := amzdate[:8]
+	req.Header.Set("X-Amz-Date", amzdate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	if req.ContentLength > 0 {
+		headers["content-length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	qkeys := make([]string, 0, len(query))
+	for k := range query {
+		qkeys = append(qkeys, k)
+	}
+	sort.Strings(qkeys)
+	qparts := make([]string, 0, len(qkeys))
+	for _, k := range qkeys {
+		vals := query[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			qparts = append(qparts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Replace(strings.Join(qparts, "&"), "/", "%2F", -1),
+		canonicalHeaders,
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	crhash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(crhash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes everything but the unreserved characters and "/".
+func s3Escape(s string) string {
+	const hexdigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexdigits[c>>4])
+		b.WriteByte(hexdigits[c&15])
+	}
+	return b.String()
+}
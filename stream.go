@@ -0,0 +1,147 @@
+package goboots
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// streamed outputs are flushed every streamFlushRows rows or
+// streamFlushInterval, whichever comes first.
+const (
+	streamFlushRows     = 512
+	streamFlushInterval = time.Second
+)
+
+// RowIterator returns the next row of OutputCSV. It returns io.EOF
+// after the last row.
+type RowIterator func() ([]string, error)
+
+// ValueIterator returns the next value of OutputNDJSON. It returns io.EOF
+// after the last value.
+type ValueIterator func() (interface{}, error)
+
+// OutputCSV streams a CSV (text/csv) with the header (if not nil) and the
+// rows returned by rows, flushing periodically. It stops if the request is
+// cancelled.
+//
+//	return in.OutputCSV([]string{"id", "name"}, rows).Attachment("users.csv")
+func (in *In) OutputCSV(header []string, rows RowIterator) *Out {
+	o := in.newOut(outCSV)
+	o.contentObj = header
+	o.rows = rows
+	return o
+}
+
+// OutputNDJSON streams the values returned by values as newline delimited
+// JSON (application/x-ndjson), flushing periodically. It stops if the
+// request is cancelled.
+func (in *In) OutputNDJSON(values ValueIterator) *Out {
+	o := in.newOut(outNDJSON)
+	o.values = values
+	return o
+}
+
+// Attachment makes the browser download the output as filename.
+func (o *Out) Attachment(filename string) *Out {
+	o.attachment = filename
+	return o
+}
+
+func (o *Out) renderCSV(w http.ResponseWriter) {
+	var cw *csv.Writer
+	o.renderStream(w, "text/csv; charset=utf-8", func(buf *bytes.Buffer) error {
+		if cw == nil {
+			cw = csv.NewWriter(buf)
+			if header, _ := o.contentObj.([]string); header != nil {
+				cw.Write(header)
+			}
+		}
+		row, err := o.rows()
+		if err != nil {
+			cw.Flush()
+			return err
+		}
+		cw.Write(row)
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+func (o *Out) renderNDJSON(w http.ResponseWriter) {
+	o.renderStream(w, "application/x-ndjson", func(buf *bytes.Buffer) error {
+		v, err := o.values()
+		if err != nil {
+			return err
+		}
+		b, err := marshalCodec("application/json", v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+		return nil
+	})
+}
+
+// renderStream calls next until it returns io.EOF, writing what it
+// buffered periodically. Errors before the first write answer 500; after
+// that, the response is cut.
+func (o *Out) renderStream(w http.ResponseWriter, ctype string, next func(buf *bytes.Buffer) error) {
+	buf := renderBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putRenderBuffer(buf)
+	flusher, _ := w.(http.Flusher)
+	committed := false
+	commit := func() error {
+		if !committed {
+			committed = true
+			if len(w.Header().Get("Content-Type")) < 1 {
+				w.Header().Set("Content-Type", ctype)
+			}
+			if len(o.attachment) > 0 {
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": o.attachment}))
+			}
+			o.writeStatus(w)
+		}
+		_, err := w.Write(buf.Bytes())
+		buf.Reset()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	}
+	n := 0
+	last := time.Now()
+	for {
+		if o.ctx != nil && o.ctx.Err() != nil {
+			// the client is gone
+			return
+		}
+		err := next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !committed {
+				o.renderError(w, err)
+				return
+			}
+			if o.app != nil {
+				o.app.Logger.Println("stream error:", err.Error())
+			}
+			return
+		}
+		n++
+		if n%streamFlushRows == 0 || time.Since(last) >= streamFlushInterval {
+			if commit() != nil {
+				return
+			}
+			last = time.Now()
+		}
+	}
+	commit()
+}
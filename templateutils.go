@@ -3,9 +3,12 @@ package goboots
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"path"
 	"strings"
+	"text/template"
+	"text/template/parse"
 )
 
 //TODO: !IMPORTANT check for a way to avoid infinite loop cycles!
@@ -117,3 +120,104 @@ func parseTemplateIncludeDeps(templateProcessor TemplateProcessor, basePath, vie
 	}
 	return stackb.Bytes(), nil
 }
+
+// URL builds the URL of a controller action using the reverse router.
+// args are key/value pairs (e.g. URL("Posts.Show", "id", 5)); values that are
+// not route args go to the query string. It's registered as the "url"
+// template function:
+//
+//	<a href="{{url "Posts.Show" "id" .ID}}">
+func (a *App) URL(action string, args ...interface{}) (string, error) {
+	if a.Router == nil {
+		return "", errors.New("url: no routes loaded")
+	}
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("url: %s: args must be key/value pairs", action)
+	}
+	argValues := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			return "", fmt.Errorf("url: %s: arg key %v is not a string", action, args[i])
+		}
+		argValues[key] = fmt.Sprint(args[i+1])
+	}
+	if !a.Router.HasAction(action) {
+		return "", fmt.Errorf("url: no route for action %s", action)
+	}
+	def := a.Router.Reverse(action, argValues)
+	if def == nil {
+		return "", fmt.Errorf("url: no route for action %s", action)
+	}
+	return def.Url, nil
+}
+
+// checkTemplateURLs makes sure that every {{url "Controller.Action"}} call
+// of the template points to an existing route.
+func (a *App) checkTemplateURLs(tpl *template.Template) error {
+	if a.Router == nil || !a.builtinURLFunc {
+		return nil
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		var err error
+		walkTemplateNode(t.Tree.Root, func(cmd *parse.CommandNode) {
+			if err != nil || len(cmd.Args) < 2 {
+				return
+			}
+			if id, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || id.Ident != "url" {
+				return
+			}
+			if action, ok := cmd.Args[1].(*parse.StringNode); ok && !a.Router.HasAction(action.Text) {
+				err = fmt.Errorf("template %s: url: no route for action %s", t.Name(), action.Text)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTemplateNode calls fn for every command of the template tree.
+func walkTemplateNode(node parse.Node, fn func(cmd *parse.CommandNode)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, v := range n.Nodes {
+			walkTemplateNode(v, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplateNode(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, v := range n.Cmds {
+			walkTemplateNode(v, fn)
+		}
+	case *parse.CommandNode:
+		fn(n)
+		for _, v := range n.Args {
+			walkTemplateNode(v, fn)
+		}
+	case *parse.IfNode:
+		walkTemplateNode(n.Pipe, fn)
+		walkTemplateNode(n.List, fn)
+		walkTemplateNode(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplateNode(n.Pipe, fn)
+		walkTemplateNode(n.List, fn)
+		walkTemplateNode(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplateNode(n.Pipe, fn)
+		walkTemplateNode(n.List, fn)
+		walkTemplateNode(n.ElseList, fn)
+	case *parse.TemplateNode:
+		walkTemplateNode(n.Pipe, fn)
+	}
+}
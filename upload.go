@@ -0,0 +1,220 @@
+package goboots
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UploadOptions limits the request bodies and the uploaded files.
+// Zero values fall back to the AppConfig limits (MaxBodySize,
+// MaxUploadFileSize and UploadAllowedTypes).
+type UploadOptions struct {
+	MaxBodySize  int64    // bytes of the whole request body
+	MaxFileSize  int64    // bytes of each file
+	AllowedTypes []string // sniffed MIME types, e.g. "image/png", "image/*"
+}
+
+// UploadedFile is a file of a multipart request.
+type UploadedFile struct {
+	Field        string // e.g. avatar
+	Filename     string // base name sent by the client, e.g. me.png
+	Size         int64
+	ContentType  string // sniffed from the content, e.g. image/png
+	DeclaredType string // sent by the client
+	Key          string // set by SaveTo
+	Header       *multipart.FileHeader
+}
+
+type uploadContextKey struct{}
+
+// limitedBody is a request body wrapped by http.MaxBytesReader. The
+// original body is kept so a route can change the limit.
+type limitedBody struct {
+	io.ReadCloser
+	orig io.ReadCloser
+}
+
+// UploadLimits returns a filter that sets the upload limits of a route:
+//
+//	app.RegisterFilter("avatar", goboots.UploadLimits(goboots.UploadOptions{
+//		MaxFileSize:  2 << 20,
+//		AllowedTypes: []string{"image/png", "image/jpeg"},
+//	}))
+//
+//	POST /avatar Users.Avatar [avatar]
+func UploadLimits(opts UploadOptions) Filter {
+	return func(in *In) bool {
+		if in.R == nil {
+			return true
+		}
+		in.R = in.R.WithContext(context.WithValue(in.R.Context(), uploadContextKey{}, opts))
+		if opts.MaxBodySize > 0 {
+			limitBody(in.W, in.R, opts.MaxBodySize)
+		}
+		in.reqbodyw = &InBodyWrapper{in.R}
+		return true
+	}
+}
+
+// limitBody limits the body of r to n bytes, replacing a previous limit.
+func limitBody(w http.ResponseWriter, r *http.Request, n int64) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	orig := r.Body
+	if lb, ok := r.Body.(*limitedBody); ok {
+		orig = lb.orig
+	}
+	r.Body = &limitedBody{http.MaxBytesReader(w, orig, n), orig}
+}
+
+// uploadOptions merges the route limits with the app limits.
+func (in *In) uploadOptions() UploadOptions {
+	var opts UploadOptions
+	if in.R != nil {
+		opts, _ = in.R.Context().Value(uploadContextKey{}).(UploadOptions)
+	}
+	if in.App != nil {
+		if opts.MaxBodySize == 0 {
+			opts.MaxBodySize = in.App.Config.MaxBodySize
+		}
+		if opts.MaxFileSize == 0 {
+			opts.MaxFileSize = in.App.Config.MaxUploadFileSize
+		}
+		if len(opts.AllowedTypes) == 0 {
+			opts.AllowedTypes = in.App.Config.UploadAllowedTypes
+		}
+	}
+	return opts
+}
+
+// parseMultipartForm parses a multipart body, answering 413 if it's
+// larger than the body limit.
+func parseMultipartForm(r *http.Request) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	if err := r.ParseMultipartForm(defaultMaxMemory); err != nil {
+		if isBodyTooLarge(err) {
+			return NewHTTPError(http.StatusRequestEntityTooLarge, "", err)
+		}
+		if err == http.ErrNotMultipart {
+			return NewHTTPError(http.StatusBadRequest, "", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// isBodyTooLarge checks if err comes from a body limit
+// (http.MaxBytesReader), even if it was wrapped.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// Upload returns the first file of the form field key. It answers 413 if
+// the body or the file is too large and 415 if the sniffed type isn't
+// allowed (HTTPError).
+func (in *In) Upload(key string) (*UploadedFile, error) {
+	files, err := in.Uploads(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) < 1 {
+		return nil, NewHTTPError(http.StatusBadRequest, "missing file "+key, http.ErrMissingFile)
+	}
+	return files[0], nil
+}
+
+// Uploads returns the files of the form field key (see Upload).
+func (in *In) Uploads(key string) ([]*UploadedFile, error) {
+	opts := in.uploadOptions()
+	if opts.MaxBodySize > 0 {
+		if _, ok := in.R.Body.(*limitedBody); !ok {
+			limitBody(in.W, in.R, opts.MaxBodySize)
+		}
+	}
+	if err := parseMultipartForm(in.R); err != nil {
+		return nil, err
+	}
+	headers := in.R.MultipartForm.File[key]
+	files := make([]*UploadedFile, 0, len(headers))
+	for _, fh := range headers {
+		f, err := newUploadedFile(key, fh, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func newUploadedFile(field string, fh *multipart.FileHeader, opts UploadOptions) (*UploadedFile, error) {
+	f := &UploadedFile{
+		Field:        field,
+		Filename:     filepath.Base(strings.Replace(fh.Filename, "\\", "/", -1)),
+		Size:         fh.Size,
+		DeclaredType: fh.Header.Get("Content-Type"),
+		Header:       fh,
+	}
+	if opts.MaxFileSize > 0 && f.Size > opts.MaxFileSize {
+		return nil, NewHTTPError(http.StatusRequestEntityTooLarge, "file "+f.Filename+" is larger than "+strconv.FormatInt(opts.MaxFileSize, 10)+" bytes", nil)
+	}
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	f.ContentType, _, _ = mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	if !typeAllowed(f.ContentType, opts.AllowedTypes) {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "file "+f.Filename+" of type "+f.ContentType+" is not allowed", nil)
+	}
+	return f, nil
+}
+
+// typeAllowed checks a media type against an allowlist like
+// "image/png", "image/*" (an empty list allows everything).
+func typeAllowed(mtype string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == mtype || a == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(a, "/*") && strings.HasPrefix(mtype, a[:len(a)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the uploaded file.
+func (f *UploadedFile) Open() (multipart.File, error) {
+	return f.Header.Open()
+}
+
+// SaveTo stores the file as key in storage.
+func (f *UploadedFile) SaveTo(storage Storage, key string) error {
+	file, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := storage.Put(key, file, f.Size, f.ContentType); err != nil {
+		return err
+	}
+	f.Key = key
+	return nil
+}